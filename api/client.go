@@ -0,0 +1,32 @@
+package api
+
+import (
+	"context"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics/types"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/task"
+)
+
+// MetricHandler 指标数据处理回调，data 为按指标编号注册的具体类型（如 types.CpuInfo、[]types.NetInfo，
+// 见 metrics.Registry），未注册的指标编号为 map[string]interface{} 等通用类型；
+// 同一条指标数据的各回调与 Options.Metrics、Options.Liveness 共用同一次解析，回调不应修改 data
+type MetricHandler func(metricCode string, data interface{})
+
+// TaskHandler 任务回调数据处理回调
+type TaskHandler func(data task.TaskBackJson)
+
+// Client 探针通信客户端
+type Client interface {
+	// RegisterMetricHandler 注册指标数据处理回调
+	RegisterMetricHandler(handler MetricHandler)
+	// RegisterTaskHandler 注册任务回调数据处理回调
+	RegisterTaskHandler(handler TaskHandler)
+	// StartListening 开始监听探针数据，阻塞直到串口连接断开
+	StartListening() error
+	// CollectMetric 下发指标采集请求并等待探针应答，ctx 未设置截止时间时使用 Options.Timeout
+	CollectMetric(ctx context.Context, metricCode string) (types.MetricsHostInfo, error)
+	// CollectMetricInto 下发指标采集请求并将应答的指标数据解析到 v 中
+	CollectMetricInto(ctx context.Context, metricCode string, v interface{}) error
+	// Close 关闭客户端及所有连接
+	Close() error
+}
@@ -0,0 +1,447 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/xuchao-ovo/agent-sdk-go/global"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/agent"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics/types"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/serial"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/task"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/transport"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/utils"
+	"go.uber.org/zap"
+)
+
+// ErrClientClosed 客户端已关闭
+var ErrClientClosed = errors.New("客户端已关闭")
+
+// pendingCollect 等待探针应答的采集请求
+type pendingCollect struct {
+	taskID      int
+	metricsCode string
+	reply       chan types.MetricsHostInfo
+}
+
+type client struct {
+	opts Options
+	log  *zap.Logger
+	pool *utils.TaskIDPool
+
+	session *serial.Session // 探针会话，持有各通道的重组缓存
+	writer  *serial.Writer  // 物理串口通道写入器，持有独立的序列号
+
+	connMutex   sync.Mutex         // 保护重连时替换的连接
+	serialConn  io.ReadWriteCloser // 物理串口通道（.fa00）
+	taskConn    io.ReadWriteCloser // 任务通道（.fa）
+	collectConn io.ReadWriteCloser // 数据采集通道（.fa2）
+
+	handlerMutex   sync.RWMutex
+	metricHandlers []MetricHandler
+	taskHandlers   []TaskHandler
+
+	// 等待探针应答的采集请求，按下发顺序排列
+	pendingMutex sync.Mutex
+	pending      []*pendingCollect
+
+	ctx       context.Context // 客户端生命周期，Close 时取消以停止监听
+	cancel    context.CancelFunc
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// NewClient 创建客户端并建立与探针的连接
+func NewClient(opts Options) (Client, error) {
+	if opts.SerialPort == "" {
+		return nil, errors.New("串口地址不能为空")
+	}
+	opts = opts.withDefaults()
+
+	log, err := opts.newLogger()
+	if err != nil {
+		return nil, fmt.Errorf("初始化日志失败: %w", err)
+	}
+
+	c := &client{
+		opts:   opts,
+		log:    log,
+		pool:   utils.NewTaskIDPool(1, 254),
+		closed: make(chan struct{}),
+	}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+
+	if c.serialConn, err = transport.Open(opts.Network, opts.SerialPort, opts.TTY); err != nil {
+		return nil, fmt.Errorf("连接串口[%s]失败: %w", opts.SerialPort, err)
+	}
+	writerConfig := opts.Writer
+	if opts.Reliable && writerConfig.RetransmitWindow == 0 {
+		writerConfig.RetransmitWindow = DefaultRetransmitWindow
+	}
+	if opts.EncryptionKey != nil {
+		if writerConfig.Sealer, err = protocol.NewSealer(opts.EncryptionKey, protocol.RoleHost); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("初始化加密失败: %w", err)
+		}
+	}
+	if c.writer, err = serial.NewWriter(c.serialConn, c.pool, writerConfig); err != nil {
+		_ = c.Close()
+		return nil, err
+	}
+	c.session = serial.NewSession(opts.KvmID, serial.SessionConfig{
+		Logger:         log,
+		Handler:        c.processMessage,
+		Writer:         c.writer,
+		Reliable:       opts.Reliable,
+		Sealer:         writerConfig.Sealer,
+		SequencePolicy: opts.SequencePolicy,
+		MaxFrameSize:   opts.MaxFrameSize,
+		OnDiscard:      opts.OnDiscard,
+		OnEvent:        opts.OnEvent,
+		IdleTimeout:    opts.IdleTimeout,
+		MaxMessageSize: opts.MaxMessageSize,
+		MemoryBudget:   opts.MemoryBudget,
+		Registry:       opts.Registry,
+	})
+	if opts.TaskPort != "" {
+		if c.taskConn, err = transport.Open(opts.Network, opts.TaskPort, opts.TTY); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("连接任务通道[%s]失败: %w", opts.TaskPort, err)
+		}
+	}
+	if opts.CollectPort != "" {
+		if c.collectConn, err = transport.Open(opts.Network, opts.CollectPort, opts.TTY); err != nil {
+			_ = c.Close()
+			return nil, fmt.Errorf("连接采集通道[%s]失败: %w", opts.CollectPort, err)
+		}
+	}
+	return c, nil
+}
+
+// RegisterMetricHandler 注册指标数据处理回调
+func (c *client) RegisterMetricHandler(handler MetricHandler) {
+	c.handlerMutex.Lock()
+	defer c.handlerMutex.Unlock()
+	c.metricHandlers = append(c.metricHandlers, handler)
+}
+
+// RegisterTaskHandler 注册任务回调数据处理回调
+func (c *client) RegisterTaskHandler(handler TaskHandler) {
+	c.handlerMutex.Lock()
+	defer c.handlerMutex.Unlock()
+	c.taskHandlers = append(c.taskHandlers, handler)
+}
+
+// StartListening 开始监听探针数据
+func (c *client) StartListening() error {
+	select {
+	case <-c.closed:
+		return ErrClientClosed
+	default:
+	}
+
+	if c.opts.Reconnect {
+		return c.listenReconnect()
+	}
+
+	if c.taskConn != nil {
+		go c.serve(c.session.ServeTask, c.taskConn)
+	}
+	if c.collectConn != nil {
+		go c.serve(c.session.ServeCollect, c.collectConn)
+	}
+	if c.opts.Handshake {
+		if err := c.session.Handshake(); err != nil {
+			return fmt.Errorf("协议握手失败: %w", err)
+		}
+	}
+	err := c.session.ServeSerial(c.ctx, c.serialConn)
+
+	select {
+	case <-c.closed:
+		return nil
+	default:
+		return err
+	}
+}
+
+// listenReconnect 托管监听各通道，连接断开后自动重连，直到客户端关闭
+func (c *client) listenReconnect() error {
+	if c.taskConn != nil {
+		go c.serveReconnect(serial.ChannelTask, c.opts.TaskPort, &c.taskConn, nil)
+	}
+	if c.collectConn != nil {
+		go c.serveReconnect(serial.ChannelCollect, c.opts.CollectPort, &c.collectConn, nil)
+	}
+	c.serveReconnect(serial.ChannelSerial, c.opts.SerialPort, &c.serialConn, func(conn io.ReadWriteCloser) error {
+		c.writer.SetConn(conn)
+		if c.opts.Handshake {
+			if err := c.session.Handshake(); err != nil {
+				return fmt.Errorf("协议握手失败: %w", err)
+			}
+		}
+		return nil
+	})
+	return nil
+}
+
+// serveReconnect 托管监听单个通道，首次使用 NewClient 建立的连接，之后按地址重新建立连接，
+// 新连接记录到 slot 以便 Close 关闭
+func (c *client) serveReconnect(channel serial.Channel, address string, slot *io.ReadWriteCloser, onConnect func(io.ReadWriteCloser) error) {
+	dial := transport.NewDialer(c.opts.Network, address, c.opts.TTY)
+	c.connMutex.Lock()
+	first := *slot
+	c.connMutex.Unlock()
+	err := c.session.ServeReconnect(c.ctx, channel, serial.ReconnectConfig{
+		Dial: func(ctx context.Context) (io.ReadWriteCloser, error) {
+			if first != nil {
+				conn := first
+				first = nil
+				return conn, nil
+			}
+			return dial(ctx)
+		},
+		Backoff: c.opts.Backoff,
+		OnConnect: func(conn io.ReadWriteCloser) error {
+			c.connMutex.Lock()
+			*slot = conn
+			c.connMutex.Unlock()
+			if onConnect != nil {
+				return onConnect(conn)
+			}
+			return nil
+		},
+		OnState: c.opts.OnState,
+	})
+	if err != nil && c.ctx.Err() == nil {
+		c.log.Warn("通道监听停止", zap.Stringer("channel", channel), zap.Error(err))
+	}
+}
+
+// serve 在后台监听可选通道，非主动关闭导致的停止记录日志
+func (c *client) serve(serve func(context.Context, io.ReadWriteCloser) error, conn io.ReadWriteCloser) {
+	if err := serve(c.ctx, conn); err != nil && c.ctx.Err() == nil {
+		c.log.Warn("通道监听停止", zap.Error(err))
+	}
+}
+
+// CollectMetric 下发指标采集请求并等待探针应答
+func (c *client) CollectMetric(ctx context.Context, metricCode string) (types.MetricsHostInfo, error) {
+	request, err := metrics.NewCollectRequest(metricCode)
+	if err != nil {
+		return types.MetricsHostInfo{}, err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+
+	taskID, err := c.pool.GetTaskID()
+	if err != nil {
+		return types.MetricsHostInfo{}, err
+	}
+	defer c.pool.RecycleTaskID(taskID)
+
+	p := &pendingCollect{
+		taskID:      taskID,
+		metricsCode: metricCode,
+		reply:       make(chan types.MetricsHostInfo, 1),
+	}
+	c.pendingMutex.Lock()
+	c.pending = append(c.pending, p)
+	c.pendingMutex.Unlock()
+	defer c.cancelPending(p)
+
+	if err = c.writer.WriteTask(global.MetricCollect, taskID, request); err != nil {
+		return types.MetricsHostInfo{}, err
+	}
+
+	select {
+	case info := <-p.reply:
+		return info, nil
+	case <-ctx.Done():
+		return types.MetricsHostInfo{}, fmt.Errorf("采集指标[%s]失败, taskID: %d: %w", metricCode, taskID, ctx.Err())
+	case <-c.closed:
+		return types.MetricsHostInfo{}, ErrClientClosed
+	}
+}
+
+// CollectMetricInto 下发指标采集请求并将应答的指标数据解析到 v 中
+func (c *client) CollectMetricInto(ctx context.Context, metricCode string, v interface{}) error {
+	info, err := c.CollectMetric(ctx, metricCode)
+	if err != nil {
+		return err
+	}
+	return info.DecodeData(v)
+}
+
+// Close 关闭客户端及所有连接
+func (c *client) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.cancel()
+		c.connMutex.Lock()
+		conns := []io.ReadWriteCloser{c.serialConn, c.taskConn, c.collectConn}
+		c.connMutex.Unlock()
+		for _, conn := range conns {
+			if conn == nil {
+				continue
+			}
+			if closeErr := conn.Close(); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+		_ = c.log.Sync()
+	})
+	return err
+}
+
+// cancelPending 移除等待中的采集请求
+func (c *client) cancelPending(p *pendingCollect) {
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	for i, pending := range c.pending {
+		if pending == p {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return
+		}
+	}
+}
+
+// resolvePending 将指标数据交给对应的采集请求：物理串口通道的应答携带请求的任务ID，只按任务ID关联，
+// 任务ID不一致的是探针主动上报的数据；旧版通道的应答不携带请求的任务ID，交给最早等待该指标的采集请求
+func (c *client) resolvePending(channel serial.Channel, taskID int, info types.MetricsHostInfo) {
+	correlated := channel == serial.ChannelSerial && taskID > 0
+	c.pendingMutex.Lock()
+	defer c.pendingMutex.Unlock()
+	match := -1
+	for i, pending := range c.pending {
+		if pending.metricsCode != info.MetricsCode {
+			continue
+		}
+		if !correlated || pending.taskID == taskID {
+			match = i
+			break
+		}
+	}
+	if match == -1 {
+		return
+	}
+	c.pending[match].reply <- info
+	c.pending = append(c.pending[:match], c.pending[match+1:]...)
+}
+
+// processMessage 处理会话重组完成的完整数据
+func (c *client) processMessage(msg serial.Message) error {
+	if msg.Channel == serial.ChannelTask {
+		return c.processTaskData(msg.PacketType, msg.Data, msg.KvmID)
+	}
+	switch msg.PacketType {
+	case global.MetricCollect:
+		info, err := c.parseMetric(msg.Data, msg.KvmID)
+		if err != nil {
+			return err
+		}
+		c.resolvePending(msg.Channel, msg.TaskID, info)
+		return c.dispatchMetric(info)
+	case global.TaskCollect:
+		return c.dispatchTask(msg.Data)
+	default:
+		return fmt.Errorf("agent[%s] 未知数据类型: %d", msg.KvmID, msg.PacketType)
+	}
+}
+
+// parseMetric 解析指标数据
+func (c *client) parseMetric(data []byte, kvmID string) (types.MetricsHostInfo, error) {
+	var info types.MetricsHostInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, err
+	}
+	if info.KvmID == "" {
+		info.KvmID = kvmID
+	}
+	return info, nil
+}
+
+// dispatchMetric 将指标数据解析一次，交给指标回调、指标分发器与存活跟踪，都不需要时不解析
+func (c *client) dispatchMetric(info types.MetricsHostInfo) error {
+	c.handlerMutex.RLock()
+	handlers := c.metricHandlers
+	c.handlerMutex.RUnlock()
+	dispatch := c.opts.Metrics != nil && c.opts.Metrics.Handles(info.MetricsCode)
+	heartbeat := c.opts.Liveness != nil && info.MetricsCode == agent.HeartbeatCode
+	if len(handlers) == 0 && !dispatch && !heartbeat {
+		return nil
+	}
+	value, err := c.decodeMetric(info)
+	if err != nil {
+		return err
+	}
+	for _, handler := range handlers {
+		handler(info.MetricsCode, value)
+	}
+	if dispatch {
+		if err = c.opts.Metrics.DispatchValue(info, value); err != nil {
+			return err
+		}
+	}
+	if heartbeat {
+		beat, ok := value.(types.HeartBeatInfo)
+		if !ok {
+			// 心跳指标注册为其他类型，由存活跟踪自行解析
+			return c.opts.Liveness.HandleMetric(info)
+		}
+		c.opts.Liveness.Heartbeat(info.KvmID, beat)
+	}
+	return nil
+}
+
+// decodeMetric 按指标分发器的注册表（未设置时为 metrics.DefaultRegistry）将指标数据解析为具体类型，
+// 未注册的指标编号解析为通用类型
+func (c *client) decodeMetric(info types.MetricsHostInfo) (interface{}, error) {
+	registry := metrics.DefaultRegistry
+	if c.opts.Metrics != nil {
+		registry = c.opts.Metrics.Registry()
+	}
+	if _, ok := registry.Type(info.MetricsCode); ok {
+		return registry.Decode(info)
+	}
+	var data interface{}
+	if err := info.DecodeData(&data); err != nil {
+		return nil, fmt.Errorf("解析指标[%s]数据失败: %w", info.MetricsCode, err)
+	}
+	return data, nil
+}
+
+// processTaskData 处理任务通道的完整数据
+func (c *client) processTaskData(packetType int, data []byte, kvmID string) error {
+	switch packetType {
+	case global.TaskCallBackCollect, global.OldAgentBackCollect:
+		return c.dispatchTask(data)
+	default:
+		return fmt.Errorf("agent[%s] 未知任务数据类型: %d", kvmID, packetType)
+	}
+}
+
+// dispatchTask 解析任务回调数据并分发给任务回调
+func (c *client) dispatchTask(data []byte) error {
+	var taskBack task.TaskBackJson
+	if err := json.Unmarshal(data, &taskBack); err != nil {
+		return err
+	}
+	c.handlerMutex.RLock()
+	handlers := c.taskHandlers
+	c.handlerMutex.RUnlock()
+	for _, handler := range handlers {
+		handler(taskBack)
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/xuchao-ovo/agent-sdk-go/global"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/agent"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics/types"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/serial"
+)
+
+// decodes counted 的解析次数
+var decodes int
+
+// counted 记录解析次数的指标数据
+type counted struct {
+	Value int
+}
+
+func (c *counted) UnmarshalJSON(data []byte) error {
+	decodes++
+	return json.Unmarshal(data, &c.Value)
+}
+
+// metricMessage 数据采集通道上 code 指标数据的完整数据
+func metricMessage(t *testing.T, code string, v interface{}) serial.Message {
+	t.Helper()
+	data, err := json.Marshal(map[string]interface{}{"kvmId": "vm", "metricsCode": code, "metricsData": v})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return serial.Message{KvmID: "vm", Channel: serial.ChannelCollect, PacketType: global.MetricCollect, Data: data}
+}
+
+func TestDispatchMetricDecodesOnce(t *testing.T) {
+	registry := metrics.NewRegistry()
+	registry.Register("PC99", counted{})
+	dispatcher := metrics.NewDispatcher(registry)
+	var typed []int
+	if err := metrics.OnMetric(dispatcher, "PC99", func(_ types.MetricsHostInfo, value counted) {
+		typed = append(typed, value.Value)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	tracker := agent.NewTracker(agent.TrackerConfig{})
+	c := &client{opts: Options{Metrics: dispatcher, Liveness: tracker}}
+	var received []interface{}
+	c.RegisterMetricHandler(func(_ string, data interface{}) { received = append(received, data) })
+	c.RegisterMetricHandler(func(_ string, data interface{}) { received = append(received, data) })
+
+	// 指标回调与分发器共用同一次解析
+	decodes = 0
+	if err := c.processMessage(metricMessage(t, "PC99", 5)); err != nil {
+		t.Fatal(err)
+	}
+	if decodes != 1 {
+		t.Fatalf("got %d decodes, want 1", decodes)
+	}
+	if len(typed) != 1 || typed[0] != 5 {
+		t.Fatalf("dispatcher got %v", typed)
+	}
+	if len(received) != 2 || received[0] != (counted{Value: 5}) || received[1] != received[0] {
+		t.Fatalf("handlers got %v, want the typed value", received)
+	}
+
+	// 心跳交给存活跟踪与指标回调，未注册的指标编号回调收到通用类型
+	received = nil
+	if err := c.processMessage(metricMessage(t, agent.HeartbeatCode, types.HeartBeatInfo{HostName: "host"})); err != nil {
+		t.Fatal(err)
+	}
+	if status, ok := tracker.Status("vm"); !ok || status.HeartBeat.HostName != "host" {
+		t.Fatalf("got status %+v, %v", status, ok)
+	}
+	if beat, ok := received[0].(types.HeartBeatInfo); !ok || beat.HostName != "host" {
+		t.Fatalf("handler got %#v, want types.HeartBeatInfo", received[0])
+	}
+	if err := c.processMessage(metricMessage(t, "PC98", map[string]int{"a": 1})); err != nil {
+		t.Fatal(err)
+	}
+	if generic, ok := received[2].(map[string]interface{}); !ok || generic["a"] != 1.0 {
+		t.Fatalf("handler got %#v, want map[string]interface{}", received[2])
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/agent"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/serial"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/transport"
+	"go.uber.org/zap"
+)
+
+// 默认配置
+const (
+	DefaultNetwork = "unix"
+	DefaultTimeout = 10 * time.Second
+
+	// DefaultRetransmitWindow 启用可靠传输且未指定重传窗口时，每个任务保留的已发送帧数
+	DefaultRetransmitWindow = 64
+)
+
+// Options SDK客户端配置
+type Options struct {
+	SerialPort  string              // 物理串口通道地址（.fa00），必填；/dev/tty* 等设备按 TTY 配置打开
+	TaskPort    string              // 任务通道地址（.fa），可选
+	CollectPort string              // 数据采集通道地址（.fa2），可选
+	Network     string              // 连接类型，默认 unix
+	TTY         transport.Config    // 物理串口设备配置（波特率、数据位、校验位、停止位），默认 115200 8N1
+	KvmID       string              // 虚拟机ID，默认取串口文件名
+	LogLevel    string              // 日志级别：debug、info、warn、error
+	Logger      *zap.Logger         // 自定义日志，设置后忽略 LogLevel
+	Timeout     time.Duration       // 采集指标等待探针应答的超时时间
+	Writer      serial.WriterConfig // 串口写入配置（分片大小、帧间节奏、限速），默认兼容旧探针
+	Reliable    bool                // 启用 Ack/Nack 可靠传输，需探针支持
+	Handshake   bool                // 开始监听时与探针握手协商协议版本，探针支持时使用 v2 协议
+
+	// EncryptionKey 与该探针（KvmID）预共享的 AES 密钥（16、24 或 32 字节），设置后物理串口通道所有帧
+	// 加密认证，拒绝伪造与重放的帧并通过 OnEvent 上报安全事件，需探针支持；
+	// 每次连接通过握手交换随机数派生帧密钥，设置后始终启用 Handshake
+	EncryptionKey []byte
+	// SequencePolicy 重复或超出重放窗口数据包的处理策略（accept/log/drop），默认接受；设置 EncryptionKey 时固定丢弃
+	SequencePolicy serial.SequencePolicy
+	// MaxFrameSize 探针发送的单帧最大数据长度，超过该长度的帧视为损坏立即跳过，默认 serial.DefaultMaxFrameSize
+	MaxFrameSize int
+
+	// 未完成数据的重组限制，零值使用 serial 包默认值，负数表示不限制
+	IdleTimeout    time.Duration               // 未完成数据最长空闲时间
+	MaxMessageSize int                         // 单条重组数据最大字节数
+	MemoryBudget   int                         // 所有未完成数据最大占用字节数
+	OnDiscard      func(serial.PartialMessage) // 未完成数据被丢弃或淘汰时回调，默认记录告警日志
+
+	OnEvent func(serial.Event) // 监听异常事件回调（校验失败、序列号不连续、处理错误、断开等），默认记录日志
+
+	// Reconnect 连接断开（如 QEMU chardev socket 重建）后按 Backoff 指数退避自动重连并继续监听，
+	// 状态变化通过 OnState 上报；未启用时任一通道断开即停止监听
+	Reconnect bool
+	Backoff   transport.Backoff        // 重连退避（初始等待、最长等待、倍数、抖动），零值使用默认配置
+	OnState   func(serial.StateChange) // 连接状态变化回调（connecting/connected/disconnected），默认记录日志
+
+	// Registry 探针注册表，设置后各通道监听期间登记该探针的连接、协议版本与最近收到数据的时间，
+	// 多个客户端可共用同一注册表
+	Registry *serial.AgentRegistry
+	// Liveness 探针存活跟踪，设置后收到的心跳（PC11）记录到跟踪中，需调用方运行 Tracker.Run 检查超时
+	Liveness *agent.Tracker
+	// Metrics 按指标编号解析为具体类型的分发器，设置后每条指标数据同时交给分发器，见 metrics.OnMetric；
+	// 与指标回调、Liveness 共用同一次解析，指标数据按分发器的注册表解析
+	Metrics *metrics.Dispatcher
+}
+
+// withDefaults 填充默认配置
+func (o Options) withDefaults() Options {
+	if o.Network == "" {
+		o.Network = DefaultNetwork
+	}
+	if o.KvmID == "" {
+		base := filepath.Base(o.SerialPort)
+		o.KvmID = strings.TrimSuffix(base, filepath.Ext(base))
+	}
+	if o.LogLevel == "" {
+		o.LogLevel = "info"
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+	if o.EncryptionKey != nil {
+		o.Handshake = true
+	}
+	return o
+}
+
+// newLogger 根据日志级别创建日志
+func (o Options) newLogger() (*zap.Logger, error) {
+	if o.Logger != nil {
+		return o.Logger, nil
+	}
+	level, err := zap.ParseAtomicLevel(o.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	config := zap.NewProductionConfig()
+	config.Level = level
+	return config.Build()
+}
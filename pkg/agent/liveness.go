@@ -0,0 +1,243 @@
+// Package agent 跟踪探针状态。
+//
+// Tracker 根据 PC11 心跳（types.HeartBeatInfo）记录每个虚拟机最近一次心跳，
+// 超过配置的心跳周期数未收到心跳时将探针标记为 stale 或 offline，并通过回调上报状态变化
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics/types"
+)
+
+// HeartbeatCode 心跳指标编号
+const HeartbeatCode = "PC11"
+
+// 默认配置
+const (
+	DefaultHeartbeatInterval = 30 * time.Second // 探针心跳周期
+	DefaultStaleAfter        = 2                // 连续错过该数量的心跳后标记为 stale
+	DefaultOfflineAfter      = 5                // 连续错过该数量的心跳后标记为 offline
+)
+
+// State 探针存活状态
+type State int
+
+const (
+	StateUnknown State = iota // 尚未收到心跳
+	StateOnline               // 按周期收到心跳
+	StateStale                // 错过若干次心跳，可能网络抖动或探针繁忙
+	StateOffline              // 长时间未收到心跳
+)
+
+// String 状态名称
+func (s State) String() string {
+	switch s {
+	case StateUnknown:
+		return "unknown"
+	case StateOnline:
+		return "online"
+	case StateStale:
+		return "stale"
+	case StateOffline:
+		return "offline"
+	default:
+		return fmt.Sprintf("State(%d)", int(s))
+	}
+}
+
+// Status 探针的存活状态与最近一次心跳内容
+type Status struct {
+	KvmID         string              // 虚拟机ID
+	State         State               // 存活状态
+	LastHeartbeat time.Time           // 最近一次收到心跳的时间
+	Missed        int                 // 最近一次检查时已错过的心跳数
+	HeartBeat     types.HeartBeatInfo // 最近一次心跳内容：主机名、平台、配置、采集状态
+}
+
+// Config 探针当前的配置
+func (s Status) Config() types.Config {
+	return s.HeartBeat.Config
+}
+
+// MetricConfig 探针当前的指标采集配置
+func (s Status) MetricConfig() []types.MetricConfig {
+	return s.HeartBeat.Config.MetricConfig
+}
+
+// Transition 探针存活状态变化，首次收到心跳时 From 为 StateUnknown
+type Transition struct {
+	KvmID  string
+	From   State
+	To     State
+	Status Status // 变化后的状态
+}
+
+// TrackerConfig 存活跟踪配置，零值使用默认值
+type TrackerConfig struct {
+	Interval     time.Duration    // 探针心跳周期，默认 DefaultHeartbeatInterval
+	StaleAfter   int              // 连续错过该数量的心跳后标记为 stale，默认 DefaultStaleAfter
+	OfflineAfter int              // 连续错过该数量的心跳后标记为 offline，默认 DefaultOfflineAfter，不小于 StaleAfter
+	OnTransition func(Transition) // 状态变化回调，在调用 Heartbeat 或检查的 goroutine 中同步调用
+	Now          func() time.Time // 记录心跳与 Run 检查使用的时钟，默认 time.Now，测试时可替换
+}
+
+// Tracker 探针存活跟踪，可并发使用
+type Tracker struct {
+	config TrackerConfig
+
+	mu     sync.Mutex
+	agents map[string]*Status
+}
+
+// NewTracker 创建探针存活跟踪
+func NewTracker(config TrackerConfig) *Tracker {
+	if config.Interval <= 0 {
+		config.Interval = DefaultHeartbeatInterval
+	}
+	if config.StaleAfter <= 0 {
+		config.StaleAfter = DefaultStaleAfter
+	}
+	if config.OfflineAfter <= 0 {
+		config.OfflineAfter = DefaultOfflineAfter
+	}
+	if config.OfflineAfter < config.StaleAfter {
+		config.OfflineAfter = config.StaleAfter
+	}
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+	return &Tracker{config: config, agents: make(map[string]*Status)}
+}
+
+// Heartbeat 记录探针的一次心跳，探针恢复为 online
+func (t *Tracker) Heartbeat(kvmID string, info types.HeartBeatInfo) {
+	t.mu.Lock()
+	status, ok := t.agents[kvmID]
+	if !ok {
+		status = &Status{KvmID: kvmID}
+		t.agents[kvmID] = status
+	}
+	from := status.State
+	status.State = StateOnline
+	status.LastHeartbeat = t.config.Now()
+	status.Missed = 0
+	status.HeartBeat = info
+	snapshot := *status
+	t.mu.Unlock()
+
+	if from != StateOnline {
+		t.transition(Transition{KvmID: kvmID, From: from, To: StateOnline, Status: snapshot})
+	}
+}
+
+// HandleMetric 处理指标数据，心跳（PC11）记录到跟踪中，其他指标忽略
+func (t *Tracker) HandleMetric(info types.MetricsHostInfo) error {
+	if info.MetricsCode != HeartbeatCode {
+		return nil
+	}
+	var heartbeat types.HeartBeatInfo
+	if err := info.DecodeData(&heartbeat); err != nil {
+		return fmt.Errorf("agent[%s] 解析心跳失败: %w", info.KvmID, err)
+	}
+	t.Heartbeat(info.KvmID, heartbeat)
+	return nil
+}
+
+// Check 按 now 计算各探针错过的心跳数并更新状态
+func (t *Tracker) Check(now time.Time) {
+	var transitions []Transition
+	t.mu.Lock()
+	for kvmID, status := range t.agents {
+		status.Missed = int(now.Sub(status.LastHeartbeat) / t.config.Interval)
+		state := StateOnline
+		switch {
+		case status.Missed >= t.config.OfflineAfter:
+			state = StateOffline
+		case status.Missed >= t.config.StaleAfter:
+			state = StateStale
+		}
+		if state != status.State {
+			transitions = append(transitions, Transition{KvmID: kvmID, From: status.State, To: state})
+			status.State = state
+			transitions[len(transitions)-1].Status = *status
+		}
+	}
+	t.mu.Unlock()
+
+	sort.Slice(transitions, func(i, j int) bool { return transitions[i].KvmID < transitions[j].KvmID })
+	for _, transition := range transitions {
+		t.transition(transition)
+	}
+}
+
+// Run 每半个心跳周期按 TrackerConfig.Now 检查一次探针状态，直到 ctx 取消
+func (t *Tracker) Run(ctx context.Context) error {
+	period := t.config.Interval / 2
+	if period <= 0 {
+		period = t.config.Interval
+	}
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			t.Check(t.config.Now())
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Status 查询探针状态，未收到过该探针的心跳时 ok 为 false
+func (t *Tracker) Status(kvmID string) (status Status, ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.agents[kvmID]
+	if !ok {
+		return Status{}, false
+	}
+	return *s, true
+}
+
+// Statuses 所有探针的状态，按虚拟机ID排序
+func (t *Tracker) Statuses() []Status {
+	t.mu.Lock()
+	statuses := make([]Status, 0, len(t.agents))
+	for _, status := range t.agents {
+		statuses = append(statuses, *status)
+	}
+	t.mu.Unlock()
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].KvmID < statuses[j].KvmID })
+	return statuses
+}
+
+// Config 探针最近一次心跳上报的配置
+func (t *Tracker) Config(kvmID string) (types.Config, bool) {
+	status, ok := t.Status(kvmID)
+	return status.Config(), ok
+}
+
+// MetricConfig 探针最近一次心跳上报的指标采集配置
+func (t *Tracker) MetricConfig(kvmID string) ([]types.MetricConfig, bool) {
+	status, ok := t.Status(kvmID)
+	return status.MetricConfig(), ok
+}
+
+// Remove 停止跟踪探针，不触发状态变化回调
+func (t *Tracker) Remove(kvmID string) {
+	t.mu.Lock()
+	delete(t.agents, kvmID)
+	t.mu.Unlock()
+}
+
+// transition 上报状态变化
+func (t *Tracker) transition(transition Transition) {
+	if t.config.OnTransition != nil {
+		t.config.OnTransition(transition)
+	}
+}
@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics/types"
+)
+
+// fakeClock 测试时钟
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) advance(d time.Duration) time.Time {
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// newTestTracker 创建使用假时钟的跟踪，返回记录的状态变化
+func newTestTracker() (*Tracker, *fakeClock, *[]Transition) {
+	clock := &fakeClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var transitions []Transition
+	tracker := NewTracker(TrackerConfig{
+		Interval:     10 * time.Second,
+		StaleAfter:   2,
+		OfflineAfter: 4,
+		Now:          clock.Now,
+		OnTransition: func(transition Transition) {
+			transitions = append(transitions, transition)
+		},
+	})
+	return tracker, clock, &transitions
+}
+
+// states 状态变化的 From/To 序列
+func states(transitions []Transition) [][2]State {
+	got := make([][2]State, 0, len(transitions))
+	for _, transition := range transitions {
+		got = append(got, [2]State{transition.From, transition.To})
+	}
+	return got
+}
+
+func TestTrackerTimeout(t *testing.T) {
+	tracker, clock, transitions := newTestTracker()
+	tracker.Heartbeat("vm", types.HeartBeatInfo{HostName: "host"})
+
+	status, ok := tracker.Status("vm")
+	if !ok || status.State != StateOnline || !status.LastHeartbeat.Equal(clock.now) {
+		t.Fatalf("got status %+v after heartbeat", status)
+	}
+
+	// 错过 1 次心跳仍为 online，2 次为 stale，4 次为 offline
+	tracker.Check(clock.advance(19 * time.Second))
+	tracker.Check(clock.advance(time.Second))
+	tracker.Check(clock.advance(10 * time.Second))
+	tracker.Check(clock.advance(10 * time.Second))
+	tracker.Check(clock.advance(time.Hour))
+
+	want := [][2]State{{StateUnknown, StateOnline}, {StateOnline, StateStale}, {StateStale, StateOffline}}
+	if got := states(*transitions); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got transitions %v, want %v", got, want)
+	}
+	stale := (*transitions)[1].Status
+	if stale.Missed != 2 || stale.HeartBeat.HostName != "host" {
+		t.Fatalf("got stale status %+v", stale)
+	}
+	if status, _ = tracker.Status("vm"); status.State != StateOffline || status.Missed != 364 {
+		t.Fatalf("got status %+v, want offline with 364 missed", status)
+	}
+}
+
+func TestTrackerRecovery(t *testing.T) {
+	tracker, clock, transitions := newTestTracker()
+	tracker.Heartbeat("vm", types.HeartBeatInfo{})
+
+	// 长时间未收到心跳直接标记为 offline，收到心跳后恢复为 online
+	tracker.Check(clock.advance(time.Minute))
+	tracker.Heartbeat("vm", types.HeartBeatInfo{HostName: "back"})
+	tracker.Check(clock.advance(15 * time.Second))
+	// stale 后收到心跳同样恢复
+	tracker.Check(clock.advance(5 * time.Second))
+	tracker.Heartbeat("vm", types.HeartBeatInfo{})
+	// online 时的心跳不触发回调
+	tracker.Heartbeat("vm", types.HeartBeatInfo{})
+
+	want := [][2]State{
+		{StateUnknown, StateOnline},
+		{StateOnline, StateOffline},
+		{StateOffline, StateOnline},
+		{StateOnline, StateStale},
+		{StateStale, StateOnline},
+	}
+	if got := states(*transitions); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got transitions %v, want %v", got, want)
+	}
+	recovered := (*transitions)[2].Status
+	if recovered.Missed != 0 || recovered.HeartBeat.HostName != "back" || !recovered.LastHeartbeat.Equal(clock.now.Add(-20*time.Second)) {
+		t.Fatalf("got recovered status %+v", recovered)
+	}
+}
+
+func TestTrackerTransitionOrder(t *testing.T) {
+	tracker, clock, transitions := newTestTracker()
+	tracker.Heartbeat("vm-b", types.HeartBeatInfo{})
+	tracker.Heartbeat("vm-a", types.HeartBeatInfo{})
+	clock.advance(5 * time.Second)
+	tracker.Heartbeat("vm-c", types.HeartBeatInfo{})
+
+	// 同一次检查的状态变化按虚拟机ID排序上报，未超时的探针不上报
+	*transitions = nil
+	tracker.Check(clock.advance(15 * time.Second))
+	var got []string
+	for _, transition := range *transitions {
+		got = append(got, transition.KvmID)
+	}
+	if want := []string{"vm-a", "vm-b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got transitions for %v, want %v", got, want)
+	}
+
+	// 移除的探针不再检查
+	tracker.Remove("vm-a")
+	*transitions = nil
+	tracker.Check(clock.advance(time.Hour))
+	got = got[:0]
+	for _, transition := range *transitions {
+		got = append(got, transition.KvmID)
+	}
+	if want := []string{"vm-b", "vm-c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got transitions for %v, want %v", got, want)
+	}
+}
+
+func TestTrackerHandleMetric(t *testing.T) {
+	tracker, _, transitions := newTestTracker()
+
+	info := types.MetricsHostInfo{KvmID: "vm", MetricsCode: HeartbeatCode}
+	if err := info.SetData(types.HeartBeatInfo{HostName: "host", Config: types.Config{Version: "1.2"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.HandleMetric(info); err != nil {
+		t.Fatal(err)
+	}
+	if config, ok := tracker.Config("vm"); !ok || config.Version != "1.2" {
+		t.Fatalf("got config %+v, %v", config, ok)
+	}
+
+	// 其他指标忽略，心跳解析失败返回错误
+	if err := tracker.HandleMetric(types.MetricsHostInfo{KvmID: "other", MetricsCode: "PC01"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tracker.Status("other"); ok {
+		t.Fatal("non-heartbeat metric tracked")
+	}
+	bad := types.MetricsHostInfo{KvmID: "bad", MetricsCode: HeartbeatCode, MetricsData: []byte(`"x"`)}
+	if err := tracker.HandleMetric(bad); err == nil {
+		t.Fatal("malformed heartbeat accepted")
+	}
+	if len(*transitions) != 1 {
+		t.Fatalf("got %d transitions, want 1", len(*transitions))
+	}
+}
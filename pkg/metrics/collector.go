@@ -0,0 +1,19 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+)
+
+// CollectRequest 指标采集请求，下发给探针触发一次采集
+type CollectRequest struct {
+	MetricsCode string `json:"metricsCode"` // 指标编号
+}
+
+// NewCollectRequest 构造指标采集请求数据
+func NewCollectRequest(metricsCode string) ([]byte, error) {
+	if metricsCode == "" {
+		return nil, errors.New("指标编号不能为空")
+	}
+	return json.Marshal(CollectRequest{MetricsCode: metricsCode})
+}
@@ -1,7 +1,6 @@
 package metrics
 
 import (
-	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -10,23 +9,19 @@ import (
 
 // GetSummary 获取摘要
 func GetSummary(agentData types.MetricsHostInfo) (string, error) {
-	// 将[]interface{}转换为json
-	metricsDataJson, err := json.Marshal(agentData.MetricsData)
-	if err != nil {
-		return "", err
-	}
+	var err error
 	// 获取探针信息
 	switch agentData.MetricsCode {
 	case "PC1":
 		var systemInfo types.SystemData
-		if err = json.Unmarshal(metricsDataJson, &systemInfo); err != nil {
+		if err = agentData.DecodeData(&systemInfo); err != nil {
 			return "", err
 		}
 		// 设置摘要信息
 		agentData.Summary = fmt.Sprintf("操作系统:%s，版本: %s", systemInfo.Manufacture, systemInfo.SystemDescription)
 	case "PC2":
 		var netInfo []types.NetInfo
-		if err = json.Unmarshal(metricsDataJson, &netInfo); err != nil {
+		if err = agentData.DecodeData(&netInfo); err != nil {
 			return "", err
 		}
 		if len(netInfo) == 0 {
@@ -45,7 +40,7 @@ func GetSummary(agentData types.MetricsHostInfo) (string, error) {
 		agentData.Summary = fmt.Sprintf("共%d个网卡，IP分别为%v", len(netInfo), IPv4)
 	case "PC3":
 		var processInfo []types.ProcessInfo
-		if err = json.Unmarshal(metricsDataJson, &processInfo); err != nil {
+		if err = agentData.DecodeData(&processInfo); err != nil {
 			return "", err
 		}
 		if len(processInfo) == 0 {
@@ -60,7 +55,7 @@ func GetSummary(agentData types.MetricsHostInfo) (string, error) {
 		agentData.Summary = fmt.Sprintf("共%d个进程，共占用%.2f%% CPU、%.2f MB 内存", len(processInfo), totalCpuUseRate, totalMemoryUseRate)
 	case "PC4":
 		var portInfo []types.PortInfo
-		if err = json.Unmarshal(metricsDataJson, &portInfo); err != nil {
+		if err = agentData.DecodeData(&portInfo); err != nil {
 			return "", err
 		}
 		if len(portInfo) == 0 {
@@ -78,7 +73,7 @@ func GetSummary(agentData types.MetricsHostInfo) (string, error) {
 		agentData.Summary = fmt.Sprintf("共开放%d个端口，包括：%s 等", len(portInfo), strings.Join(portList, "、"))
 	case "PC5":
 		var arpInfo []types.ArpInfo
-		if err = json.Unmarshal(metricsDataJson, &arpInfo); err != nil {
+		if err = agentData.DecodeData(&arpInfo); err != nil {
 			return "", err
 		}
 		ipList := make([]string, 0)
@@ -96,7 +91,7 @@ func GetSummary(agentData types.MetricsHostInfo) (string, error) {
 		agentData.Summary = fmt.Sprintf("有过网络连接的IP：%s等", strings.Join(ipList, "、"))
 	case "PC6":
 		var userInfo []types.UserInfo
-		if err = json.Unmarshal(metricsDataJson, &userInfo); err != nil {
+		if err = agentData.DecodeData(&userInfo); err != nil {
 			return "", err
 		}
 		if len(userInfo) == 0 {
@@ -114,7 +109,7 @@ func GetSummary(agentData types.MetricsHostInfo) (string, error) {
 		agentData.Summary = fmt.Sprintf("共%d个用户，包括：%s等", len(userInfo), strings.Join(userList, "、"))
 	case "PC7":
 		var fileModifyInfo types.FileModifyData
-		if err = json.Unmarshal(metricsDataJson, &fileModifyInfo); err != nil {
+		if err = agentData.DecodeData(&fileModifyInfo); err != nil {
 			return "", err
 		}
 		var operate string
@@ -132,7 +127,7 @@ func GetSummary(agentData types.MetricsHostInfo) (string, error) {
 		agentData.Summary = fmt.Sprintf("文件 [%s] 被%s", fileModifyInfo.FileName, operate)
 	case "PC9":
 		var cronTaskData []types.CronTaskData
-		if err = json.Unmarshal(metricsDataJson, &cronTaskData); err != nil {
+		if err = agentData.DecodeData(&cronTaskData); err != nil {
 			return "", err
 		}
 		if len(cronTaskData) == 0 {
@@ -150,7 +145,7 @@ func GetSummary(agentData types.MetricsHostInfo) (string, error) {
 		agentData.Summary = fmt.Sprintf("%d个定时任务，包括：%s等", len(cronTaskData), strings.Join(taskList, "、"))
 	case "PC10":
 		var loginInfo []types.LoginInfo
-		if err = json.Unmarshal(metricsDataJson, &loginInfo); err != nil {
+		if err = agentData.DecodeData(&loginInfo); err != nil {
 			return "", err
 		}
 		// 设置摘要信息
@@ -168,7 +163,7 @@ func GetSummary(agentData types.MetricsHostInfo) (string, error) {
 		agentData.Summary = "探针心跳"
 	case "PC12":
 		var cpuInfo types.CpuInfo
-		if err = json.Unmarshal(metricsDataJson, &cpuInfo); err != nil {
+		if err = agentData.DecodeData(&cpuInfo); err != nil {
 			return "", err
 		}
 		agentData.Summary = fmt.Sprintf("CPU使用率：%.2f%%", cpuInfo.CpuUseRate)
@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics/types"
+)
+
+// legacyHostInfo MetricsData 为 interface{} 时的指标数据，用于对比之前的解析方式
+type legacyHostInfo struct {
+	KvmID       string      `json:"kvmId"`
+	MetricsCode string      `json:"metricsCode"`
+	MetricsData interface{} `json:"metricsData"`
+}
+
+// processPayload 包含 n 个进程的 PC3 指标数据
+func processPayload(n int) []byte {
+	procs := make([]types.ProcessInfo, n)
+	for i := range procs {
+		procs[i] = types.ProcessInfo{PId: int32(i), ProcessName: fmt.Sprintf("proc-%d", i), Account: "root", CpuUseRate: 0.5, MemoryUseRate: 1.5}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"kvmId": "vm", "metricsCode": "PC3", "metricsData": procs})
+	return data
+}
+
+// legacySummary 之前的解析方式：MetricsData 先解析为通用类型，再编码为 JSON 后解析为具体类型
+func legacySummary(data []byte) (string, error) {
+	var legacy legacyHostInfo
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(legacy.MetricsData)
+	if err != nil {
+		return "", err
+	}
+	return GetSummary(types.MetricsHostInfo{KvmID: legacy.KvmID, MetricsCode: legacy.MetricsCode, MetricsData: raw})
+}
+
+// summary 保留原始 JSON，按指标编号直接解析为具体类型
+func summary(data []byte) (string, error) {
+	var info types.MetricsHostInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", err
+	}
+	return GetSummary(info)
+}
+
+func TestGetSummaryRawData(t *testing.T) {
+	data := processPayload(10)
+	want, err := legacySummary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := summary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func BenchmarkGetSummary(b *testing.B) {
+	paths := []struct {
+		name    string
+		summary func([]byte) (string, error)
+	}{
+		{name: "legacy", summary: legacySummary},
+		{name: "raw", summary: summary},
+	}
+	for _, n := range []int{10, 2000} {
+		data := processPayload(n)
+		for _, path := range paths {
+			b.Run(fmt.Sprintf("%s/%dprocs", path.name, n), func(b *testing.B) {
+				b.SetBytes(int64(len(data)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					if _, err := path.summary(data); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics/types"
+)
+
+// ErrUnknownMetric 指标编号未注册数据类型
+var ErrUnknownMetric = errors.New("未注册的指标编号")
+
+// defaultTypes 探针上报的指标编号与数据类型，列表类指标为切片；
+// 探针未定义 PC17 的数据类型（types 中没有对应的结构），解析时返回 ErrUnknownMetric，
+// 探针上报该指标时可通过 Registry.Register 注册
+var defaultTypes = map[string]interface{}{
+	"PC1":  types.SystemData{},
+	"PC2":  []types.NetInfo{},
+	"PC3":  []types.ProcessInfo{},
+	"PC4":  []types.PortInfo{},
+	"PC5":  []types.ArpInfo{},
+	"PC6":  []types.UserInfo{},
+	"PC7":  types.FileModifyData{},
+	"PC8":  types.CommandModifyData{},
+	"PC9":  []types.CronTaskData{},
+	"PC10": []types.LoginInfo{},
+	"PC11": types.HeartBeatInfo{},
+	"PC12": types.CpuInfo{},
+	"PC13": types.DiskData{},
+	"PC14": types.MemInfo{},
+	"PC15": []types.NetSendInfo{},
+	"PC16": []types.NetRecvInfo{},
+	"PC18": []types.SoftwareData{},
+	"PC19": []types.FirewallStatus{},
+	"PC20": types.HttpPacketData{},
+	"PC21": []types.SSHInfo{},
+	"PC22": []types.RDPLog{},
+	"PC23": []types.EventLogInfo{},
+}
+
+// Registry 指标编号与 Go 类型的对应关系，可并发使用
+type Registry struct {
+	mu    sync.RWMutex
+	types map[string]reflect.Type
+}
+
+// DefaultRegistry 包含所有内置指标类型的注册表
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry 创建包含所有内置指标类型的注册表
+func NewRegistry() *Registry {
+	r := &Registry{types: make(map[string]reflect.Type, len(defaultTypes))}
+	for code, prototype := range defaultTypes {
+		r.types[code] = reflect.TypeOf(prototype)
+	}
+	return r
+}
+
+// Register 注册或替换指标编号的数据类型，prototype 为该类型的零值，如 types.CpuInfo{}
+func (r *Registry) Register(code string, prototype interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types[code] = reflect.TypeOf(prototype)
+}
+
+// Type 指标编号的数据类型
+func (r *Registry) Type(code string) (reflect.Type, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	t, ok := r.types[code]
+	return t, ok
+}
+
+// Decode 按指标编号将 MetricsData 解析为注册的类型，返回值（非指针），如 types.CpuInfo 或 []types.NetInfo
+func (r *Registry) Decode(info types.MetricsHostInfo) (interface{}, error) {
+	t, ok := r.Type(info.MetricsCode)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownMetric, info.MetricsCode)
+	}
+	v := reflect.New(t)
+	if err := decodeMetricsData(info, v.Interface()); err != nil {
+		return nil, err
+	}
+	return v.Elem().Interface(), nil
+}
+
+// Decode 使用 DefaultRegistry 解析指标数据
+func Decode(info types.MetricsHostInfo) (interface{}, error) {
+	return DefaultRegistry.Decode(info)
+}
+
+// DecodeAs 将 MetricsData 解析为 T，不检查指标编号注册的类型
+func DecodeAs[T any](info types.MetricsHostInfo) (T, error) {
+	var v T
+	err := decodeMetricsData(info, &v)
+	return v, err
+}
+
+// decodeMetricsData 将 MetricsData 解析到 v
+func decodeMetricsData(info types.MetricsHostInfo, v interface{}) error {
+	if err := info.DecodeData(v); err != nil {
+		return fmt.Errorf("解析指标[%s]数据失败: %w", info.MetricsCode, err)
+	}
+	return nil
+}
+
+// Dispatcher 按指标编号将指标数据解析为注册的类型并分发给对应的处理函数，每条指标数据只解析一次
+type Dispatcher struct {
+	registry *Registry
+
+	mu       sync.RWMutex
+	handlers map[string][]func(info types.MetricsHostInfo, value interface{}) error
+}
+
+// NewDispatcher 创建指标分发器，registry 为 nil 时使用 DefaultRegistry
+func NewDispatcher(registry *Registry) *Dispatcher {
+	if registry == nil {
+		registry = DefaultRegistry
+	}
+	return &Dispatcher{registry: registry, handlers: make(map[string][]func(types.MetricsHostInfo, interface{}) error)}
+}
+
+// OnMetric 注册指标编号的处理函数，T 须与注册表中该指标编号的类型一致，例如：
+//
+//	metrics.OnMetric[types.CpuInfo](d, "PC12", func(info types.MetricsHostInfo, cpu types.CpuInfo) { ... })
+func OnMetric[T any](d *Dispatcher, code string, fn func(info types.MetricsHostInfo, value T)) error {
+	t, ok := d.registry.Type(code)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownMetric, code)
+	}
+	if want := reflect.TypeOf((*T)(nil)).Elem(); want != t {
+		return fmt.Errorf("指标[%s]的数据类型为 %s，不是 %s", code, t, want)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.handlers[code] = append(d.handlers[code], func(info types.MetricsHostInfo, value interface{}) error {
+		v, ok := value.(T)
+		if !ok {
+			return fmt.Errorf("指标[%s]的数据类型 %T 与处理函数不一致", code, value)
+		}
+		fn(info, v)
+		return nil
+	})
+	return nil
+}
+
+// Registry 分发器使用的注册表
+func (d *Dispatcher) Registry() *Registry {
+	return d.registry
+}
+
+// Handles 是否注册了指标编号的处理函数
+func (d *Dispatcher) Handles(code string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.handlers[code]) > 0
+}
+
+// Dispatch 解析指标数据并依次调用该指标编号的处理函数，没有处理函数时不解析
+func (d *Dispatcher) Dispatch(info types.MetricsHostInfo) error {
+	if !d.Handles(info.MetricsCode) {
+		return nil
+	}
+	value, err := d.registry.Decode(info)
+	if err != nil {
+		return err
+	}
+	return d.DispatchValue(info, value)
+}
+
+// DispatchValue 将已解析的指标数据依次交给该指标编号的处理函数，value 为 Registry().Decode 的返回值，
+// 用于与其他使用方共用同一次解析
+func (d *Dispatcher) DispatchValue(info types.MetricsHostInfo, value interface{}) error {
+	d.mu.RLock()
+	handlers := d.handlers[info.MetricsCode]
+	d.mu.RUnlock()
+	var err error
+	for _, handler := range handlers {
+		if err = handler(info, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
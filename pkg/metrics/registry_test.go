@@ -0,0 +1,116 @@
+package metrics
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/metrics/types"
+)
+
+// metricInfo 编码 v 为 code 指标数据
+func metricInfo(t *testing.T, code string, v interface{}) types.MetricsHostInfo {
+	t.Helper()
+	info := types.MetricsHostInfo{KvmID: "vm", MetricsCode: code}
+	if err := info.SetData(v); err != nil {
+		t.Fatal(err)
+	}
+	return info
+}
+
+func TestRegistryDecode(t *testing.T) {
+	r := NewRegistry()
+
+	value, err := r.Decode(metricInfo(t, "PC12", types.CpuInfo{CpuUseRate: 12.5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpu, ok := value.(types.CpuInfo); !ok || cpu.CpuUseRate != 12.5 {
+		t.Fatalf("got %#v, want types.CpuInfo", value)
+	}
+	value, err = r.Decode(metricInfo(t, "PC2", []types.NetInfo{{Name: "eth0"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nets, ok := value.([]types.NetInfo); !ok || len(nets) != 1 || nets[0].Name != "eth0" {
+		t.Fatalf("got %#v, want []types.NetInfo", value)
+	}
+
+	// 未注册的编号（包括未定义数据类型的 PC17）与格式错误的数据返回错误
+	if _, err = r.Decode(types.MetricsHostInfo{MetricsCode: "PC17", MetricsData: []byte(`{}`)}); !errors.Is(err, ErrUnknownMetric) {
+		t.Fatalf("got %v, want ErrUnknownMetric", err)
+	}
+	if _, err = r.Decode(types.MetricsHostInfo{MetricsCode: "PC12", MetricsData: []byte(`[1]`)}); err == nil || !strings.Contains(err.Error(), "PC12") {
+		t.Fatalf("got %v, want decode error naming PC12", err)
+	}
+}
+
+func TestRegistryRegister(t *testing.T) {
+	type custom struct {
+		Value int `json:"value"`
+	}
+	r := NewRegistry()
+	r.Register("PC17", custom{})
+	value, err := r.Decode(metricInfo(t, "PC17", custom{Value: 7}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value != (custom{Value: 7}) {
+		t.Fatalf("got %#v", value)
+	}
+
+	// 注册只影响该注册表，替换内置类型
+	if _, ok := DefaultRegistry.Type("PC17"); ok {
+		t.Fatal("Register on a new registry changed DefaultRegistry")
+	}
+	r.Register("PC12", custom{})
+	if typ, _ := r.Type("PC12"); typ != reflect.TypeOf(custom{}) {
+		t.Fatalf("got type %v after replacing PC12", typ)
+	}
+}
+
+func TestOnMetric(t *testing.T) {
+	d := NewDispatcher(nil)
+	var got []float64
+	if err := OnMetric(d, "PC12", func(info types.MetricsHostInfo, cpu types.CpuInfo) {
+		got = append(got, cpu.CpuUseRate)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := OnMetric(d, "PC12", func(info types.MetricsHostInfo, cpu types.CpuInfo) {
+		got = append(got, -cpu.CpuUseRate)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 类型与注册表不一致、未注册的编号拒绝注册
+	if err := OnMetric(d, "PC12", func(types.MetricsHostInfo, []types.CpuInfo) {}); err == nil {
+		t.Fatal("handler with mismatched type registered")
+	}
+	if err := OnMetric(d, "PC17", func(types.MetricsHostInfo, types.CpuInfo) {}); !errors.Is(err, ErrUnknownMetric) {
+		t.Fatalf("got %v, want ErrUnknownMetric", err)
+	}
+	if !d.Handles("PC12") || d.Handles("PC13") {
+		t.Fatal("Handles does not match registered handlers")
+	}
+
+	// 处理函数按注册顺序调用，没有处理函数的指标不解析
+	if err := d.Dispatch(metricInfo(t, "PC12", types.CpuInfo{CpuUseRate: 3})); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Dispatch(types.MetricsHostInfo{MetricsCode: "PC13", MetricsData: []byte(`not json`)}); err != nil {
+		t.Fatalf("metric without handlers decoded: %v", err)
+	}
+	if want := []float64{3, -3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+
+	// 解析失败返回错误，DispatchValue 的值类型不一致时返回错误
+	if err := d.Dispatch(types.MetricsHostInfo{MetricsCode: "PC12", MetricsData: []byte(`[1]`)}); err == nil {
+		t.Fatal("malformed metric dispatched")
+	}
+	if err := d.DispatchValue(types.MetricsHostInfo{MetricsCode: "PC12"}, types.MemInfo{}); err == nil {
+		t.Fatal("mismatched value dispatched")
+	}
+}
@@ -1,8 +1,12 @@
 package types
 
 import (
-	uuid "github.com/satori/go.uuid"
+	"encoding/json"
+	"reflect"
+	"sync"
 	"time"
+
+	uuid "github.com/satori/go.uuid"
 )
 
 // SystemData 系统信息采集信息 => PC1
@@ -299,15 +303,88 @@ type Software struct {
 }
 
 type MetricsHostInfo struct {
-	AgentID     string      `json:"agentId"`     //  探针ID
-	KvmID       string      `json:"kvmId"`       // 虚拟机ID
-	MetricsCode string      `json:"metricsCode"` // 指标编号
-	MetricsName string      `json:"metricsName"` // 指标名
-	MetricsType string      `json:"metricsType"` // 轮询方式
-	Summary     string      `json:"summary"`     // 摘要
-	MetricsData interface{} `json:"metricsData"` // 指标数据
-	Level       uint        `json:"level"`       // 指标等级
-	Interval    uint        `json:"interval"`    // 采集周期
+	AgentID     string          `json:"agentId"`     //  探针ID
+	KvmID       string          `json:"kvmId"`       // 虚拟机ID
+	MetricsCode string          `json:"metricsCode"` // 指标编号
+	MetricsName string          `json:"metricsName"` // 指标名
+	MetricsType string          `json:"metricsType"` // 轮询方式
+	Summary     string          `json:"summary"`     // 摘要
+	MetricsData json.RawMessage `json:"metricsData"` // 指标数据原始 JSON，通过 DecodeData 解析为具体类型
+	Level       uint            `json:"level"`       // 指标等级
+	Interval    uint            `json:"interval"`    // 采集周期
+
+	decoded *decodedData // MetricsData 首次解析的结果，解析后复制的副本共用
+}
+
+// decodedData 指标数据首次解析的结果，之后解析为同一类型时直接复用
+type decodedData struct {
+	once  sync.Once
+	raw   json.RawMessage // 解析的原始数据，MetricsData 被替换后结果失效
+	typ   reflect.Type    // 首次解析的目标类型
+	value reflect.Value
+	err   error
+}
+
+// matches 结果是否由 data 解析得到
+func (d *decodedData) matches(data json.RawMessage) bool {
+	return len(d.raw) == len(data) && &d.raw[0] == &data[0]
+}
+
+// decode 首次调用时解析并缓存结果，之后解析为同一类型时复制缓存的结果，其他类型重新解析
+func (d *decodedData) decode(v interface{}) error {
+	target := reflect.ValueOf(v)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return json.Unmarshal(d.raw, v)
+	}
+	typ := target.Elem().Type()
+	d.once.Do(func() {
+		value := reflect.New(typ)
+		d.typ = typ
+		d.err = json.Unmarshal(d.raw, value.Interface())
+		d.value = value.Elem()
+	})
+	if typ != d.typ {
+		return json.Unmarshal(d.raw, v)
+	}
+	if d.err != nil {
+		return d.err
+	}
+	target.Elem().Set(d.value)
+	return nil
+}
+
+// DecodeData 将指标数据解析到 v 中，没有指标数据时不修改 v；
+// 首次解析的结果被缓存，之后解析为同一类型时不再重复解析，v 中的切片与映射与其他调用方共用，不应修改。
+// 首次解析不能与同一 MetricsHostInfo 的其他调用并发进行
+func (t *MetricsHostInfo) DecodeData(v interface{}) error {
+	if len(t.MetricsData) == 0 {
+		return nil
+	}
+	if t.decoded == nil || !t.decoded.matches(t.MetricsData) {
+		t.decoded = &decodedData{raw: t.MetricsData}
+	}
+	return t.decoded.decode(v)
+}
+
+// Data 兼容访问：将指标数据解析为 map[string]interface{}、[]interface{} 等通用类型，
+// 没有指标数据或解析失败时返回 nil；需要具体类型时使用 DecodeData
+func (t *MetricsHostInfo) Data() interface{} {
+	var data interface{}
+	if err := t.DecodeData(&data); err != nil {
+		return nil
+	}
+	return data
+}
+
+// SetData 将 v 编码为指标数据
+func (t *MetricsHostInfo) SetData(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	t.MetricsData = data
+	t.decoded = nil
+	return nil
 }
 
 func (t *MetricsHostInfo) ToMap() map[string]interface{} {
@@ -317,7 +394,7 @@ func (t *MetricsHostInfo) ToMap() map[string]interface{} {
 		"MetricsName": t.MetricsName,
 		"MetricsType": t.MetricsType,
 		"Summary":     t.Summary,
-		"MetricsData": t.MetricsData,
+		"MetricsData": t.Data(),
 		"Level":       t.Level,
 		"Interval":    t.Interval,
 	}
@@ -0,0 +1,70 @@
+package types
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// decodeCount 解析次数
+var decodeCount int
+
+// counted 记录解析次数的指标数据
+type counted struct {
+	Value int
+}
+
+func (c *counted) UnmarshalJSON(data []byte) error {
+	decodeCount++
+	return json.Unmarshal(data, &c.Value)
+}
+
+func TestDecodeDataCache(t *testing.T) {
+	decodeCount = 0
+	info := MetricsHostInfo{MetricsCode: "PC99", MetricsData: json.RawMessage(`7`)}
+
+	// 解析为同一类型时复用首次解析的结果，解析后复制的副本共用
+	var first, second counted
+	if err := info.DecodeData(&first); err != nil {
+		t.Fatal(err)
+	}
+	copied := info
+	if err := copied.DecodeData(&second); err != nil {
+		t.Fatal(err)
+	}
+	if first.Value != 7 || second.Value != 7 || decodeCount != 1 {
+		t.Fatalf("got %d, %d after %d decodes, want 7 after 1 decode", first.Value, second.Value, decodeCount)
+	}
+
+	// 其他类型重新解析，不替换缓存
+	var generic interface{}
+	if err := info.DecodeData(&generic); err != nil || generic != 7.0 {
+		t.Fatalf("got %v, %v", generic, err)
+	}
+	if err := info.DecodeData(&second); err != nil || decodeCount != 1 {
+		t.Fatalf("got %v after %d decodes, want cached result", err, decodeCount)
+	}
+
+	// 替换指标数据后缓存失效
+	if err := info.SetData(8); err != nil {
+		t.Fatal(err)
+	}
+	if err := info.DecodeData(&first); err != nil || first.Value != 8 {
+		t.Fatalf("got %d, %v after SetData", first.Value, err)
+	}
+	info.MetricsData = json.RawMessage(`9`)
+	if err := info.DecodeData(&first); err != nil || first.Value != 9 {
+		t.Fatalf("got %d, %v after replacing MetricsData", first.Value, err)
+	}
+	if decodeCount != 3 {
+		t.Fatalf("got %d decodes, want 3", decodeCount)
+	}
+
+	// 解析失败的结果同样缓存
+	bad := MetricsHostInfo{MetricsData: json.RawMessage(`"x"`)}
+	if err := bad.DecodeData(&first); err == nil {
+		t.Fatal("malformed data decoded")
+	}
+	if err := bad.DecodeData(&first); err == nil || decodeCount != 4 {
+		t.Fatalf("got %v after %d decodes, want cached error", err, decodeCount)
+	}
+}
@@ -0,0 +1,46 @@
+package protocol
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+)
+
+// ErrDecompressedTooLarge 解压后的数据超过长度限制
+var ErrDecompressedTooLarge = errors.New("解压后的数据超过最大长度")
+
+// Compress 使用 gzip 压缩一条完整数据，压缩数据在各帧间分片传输，所有帧的头部均设置 FlagCompressed
+func Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress 解压重组完成的 gzip 数据，limit 大于 0 时限制解压后的长度，防止异常数据占用内存
+func Decompress(data []byte, limit int) ([]byte, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var r io.Reader = zr
+	if limit > 0 {
+		r = io.LimitReader(zr, int64(limit)+1)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(out) > limit {
+		return nil, ErrDecompressedTooLarge
+	}
+	return out, nil
+}
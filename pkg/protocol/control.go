@@ -0,0 +1,174 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// 控制数据包类型，与业务数据类型共用 PacketType 字段，控制数据包均为单帧（DataEnd）
+//
+// 控制数据包的 SeqNum 属于发送方自身的序列号空间，TaskID 为被确认或缺帧的任务：
+//   - Ack：数据为该条数据最后一帧的序列号（4 字节，大端序）
+//   - Nack：数据为缺失的序列号列表（每个 4 字节，大端序），最多 MaxNackSeqs 个
+//   - Hello：版本协商，格式见 Hello，TaskID 为 0，始终使用 v1 头部且不加密发送以便旧版探针解析
+const (
+	PacketTypeAck   byte = 0xA0 // 确认：数据已完整接收，发送方可释放重传窗口
+	PacketTypeNack  byte = 0xA1 // 否认：请求重传缺失的数据包
+	PacketTypeHello byte = 0xA2 // 握手：交换支持的协议版本与能力
+)
+
+// MaxNackSeqs 单个 Nack 最多携带的序列号数量
+const MaxNackSeqs = 64
+
+// ErrBadControl 控制数据包格式错误
+var ErrBadControl = errors.New("控制数据包格式错误")
+
+// IsControl 是否为控制数据包类型
+func IsControl(packetType byte) bool {
+	return packetType == PacketTypeAck || packetType == PacketTypeNack || packetType == PacketTypeHello
+}
+
+// EncodeAck 编码 Ack 数据
+func EncodeAck(lastSeq uint32) []byte {
+	data := make([]byte, 4)
+	binary.BigEndian.PutUint32(data, lastSeq)
+	return data
+}
+
+// DecodeAck 解码 Ack 数据
+func DecodeAck(data []byte) (uint32, error) {
+	if len(data) != 4 {
+		return 0, ErrBadControl
+	}
+	return binary.BigEndian.Uint32(data), nil
+}
+
+// EncodeNack 编码 Nack 数据，超过 MaxNackSeqs 的序列号被截断
+func EncodeNack(seqs []uint32) []byte {
+	if len(seqs) > MaxNackSeqs {
+		seqs = seqs[:MaxNackSeqs]
+	}
+	data := make([]byte, 4*len(seqs))
+	for i, seq := range seqs {
+		binary.BigEndian.PutUint32(data[4*i:], seq)
+	}
+	return data
+}
+
+// DecodeNack 解码 Nack 数据
+func DecodeNack(data []byte) ([]uint32, error) {
+	if len(data)%4 != 0 || len(data)/4 > MaxNackSeqs {
+		return nil, ErrBadControl
+	}
+	seqs := make([]uint32, len(data)/4)
+	for i := range seqs {
+		seqs[i] = binary.BigEndian.Uint32(data[4*i:])
+	}
+	return seqs, nil
+}
+
+// 握手能力位
+const (
+	CapReliable uint32 = 1 << iota // 支持 Ack/Nack 可靠传输
+	CapGzip                        // 支持解压 gzip 压缩的数据（FlagCompressed），需 v2 协议
+)
+
+// helloReply Hello 标志位：应答对端的 Hello，收到后不再回复
+const helloReply byte = 0x01
+
+// Hello 握手数据，双方各发送一次，收到未应答过的 Hello 时回复自身的 Hello：
+//
+//	+-------+--------------+-------+-------------------+---------+---------------+--------+-------------+
+//	| Flags | Capabilities | Count | Versions（Count）  | SaltLen | Salt（SaltLen） | MACLen | MAC（MACLen） |
+//	+-------+--------------+-------+-------------------+---------+---------------+--------+-------------+
+//	  1       4（大端序）     1       每个 1 字节          1         可选，加密时携带    1        可选，加密时携带
+//
+// 未加密时不携带 SaltLen 之后的字段，与旧版探针的格式一致；加密时 MAC 由 Sealer.SignHello 计算
+type Hello struct {
+	Reply        bool   // 是否为对端 Hello 的应答
+	Capabilities uint32 // 能力位，见 CapReliable 等
+	Versions     []byte // 支持的协议版本
+	Salt         []byte // 加密时本端的每连接随机数，见 Sealer
+	MAC          []byte // 加密时以预共享密钥计算的认证码，覆盖 MAC 之前的全部字段
+}
+
+// EncodeHello 编码 Hello 数据
+func EncodeHello(h Hello) []byte {
+	versions := h.Versions
+	if len(versions) > 255 {
+		versions = versions[:255]
+	}
+	salt := h.Salt
+	if len(salt) > 255 {
+		salt = salt[:255]
+	}
+	mac := h.MAC
+	if len(salt) == 0 || len(mac) > 255 {
+		mac = mac[:0]
+	}
+	size := 6 + len(versions)
+	if len(salt) > 0 {
+		size += 1 + len(salt)
+	}
+	if len(mac) > 0 {
+		size += 1 + len(mac)
+	}
+	data := make([]byte, size)
+	if h.Reply {
+		data[0] = helloReply
+	}
+	binary.BigEndian.PutUint32(data[1:], h.Capabilities)
+	data[5] = byte(len(versions))
+	copy(data[6:], versions)
+	if len(salt) > 0 {
+		data[6+len(versions)] = byte(len(salt))
+		copy(data[7+len(versions):], salt)
+	}
+	if len(mac) > 0 {
+		offset := 7 + len(versions) + len(salt)
+		data[offset] = byte(len(mac))
+		copy(data[offset+1:], mac)
+	}
+	return data
+}
+
+// DecodeHello 解码 Hello 数据
+func DecodeHello(data []byte) (Hello, error) {
+	if len(data) < 6 || len(data) < 6+int(data[5]) {
+		return Hello{}, ErrBadControl
+	}
+	end := 6 + int(data[5])
+	hello := Hello{
+		Reply:        data[0]&helloReply != 0,
+		Capabilities: binary.BigEndian.Uint32(data[1:]),
+		Versions:     append([]byte(nil), data[6:end]...),
+	}
+	if rest := data[end:]; len(rest) > 0 {
+		if len(rest) < 1+int(rest[0]) {
+			return Hello{}, ErrBadControl
+		}
+		hello.Salt = append([]byte(nil), rest[1:1+int(rest[0])]...)
+		if rest = rest[1+int(rest[0]):]; len(rest) > 0 {
+			if len(rest) != 1+int(rest[0]) {
+				return Hello{}, ErrBadControl
+			}
+			hello.MAC = append([]byte(nil), rest[1:]...)
+		}
+	}
+	return hello, nil
+}
+
+// Negotiate 协商双方共同支持的最高协议版本与共同能力，没有共同版本时返回 false
+func Negotiate(local, remote Hello) (version byte, capabilities uint32, ok bool) {
+	for _, v := range local.Versions {
+		for _, r := range remote.Versions {
+			if v == r && v > version {
+				version = v
+			}
+		}
+	}
+	if version == 0 {
+		return 0, 0, false
+	}
+	return version, local.Capabilities & remote.Capabilities, true
+}
@@ -0,0 +1,196 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// 解码错误
+var (
+	ErrBadCRC     = errors.New("数据包CRC校验失败")
+	ErrBadVersion = errors.New("数据包协议版本不支持")
+	ErrTruncated  = errors.New("数据包不完整")
+)
+
+// FrameError 数据帧解码错误，携带出错帧的头部信息，
+// 可通过 errors.Is 判断 ErrBadCRC、ErrBadVersion、ErrDataTooLong、ErrTruncated
+type FrameError struct {
+	Err    error        // 错误类型
+	Header PacketHeader // 出错帧的头部，ErrTruncated 时可能不完整
+	Offset int64        // 出错帧在数据流中的偏移
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("%v（偏移: %d，序列号: %d，任务ID: %d）", e.Err, e.Offset, e.Header.SeqNum, e.Header.TaskID)
+}
+
+func (e *FrameError) Unwrap() error {
+	return e.Err
+}
+
+// magicBytes Magic Number 的字节序列
+var magicBytes = []byte{byte(MagicNumber >> 8), byte(MagicNumber & 0xFF)}
+
+// readSize 每次从 io.Reader 读取的字节数
+const readSize = 4096
+
+// Decoder 0xCAFE 协议流式解码器，按 Magic Number 定位数据帧，校验版本与CRC后输出数据包，同时接受 v1 与 v2 数据帧。
+//
+// 通过 NewDecoder(r) 从 io.Reader 读取数据；r 为 nil 时由调用方通过 Feed 投喂数据，
+// 缓存中没有完整数据帧时 Decode 返回 io.EOF，继续 Feed 后可再次调用。
+// 解码出错的数据帧返回 *FrameError，解码器已跳过该帧，可继续调用 Decode。
+//
+// 头部 DataLen 超过 SetMaxDataLen 设置的长度时立即按 ErrDataTooLong 跳过，
+// 不等待缓存该长度的数据，避免损坏的 v2 长度字段让后续数据帧长时间无法解码。
+type Decoder struct {
+	r          io.Reader
+	buf        []byte
+	offset     int64 // buf[0] 在数据流中的偏移
+	skipped    int64 // 定位 Magic Number 时跳过的字节数
+	err        error // io.Reader 返回的错误
+	maxDataLen int   // 单个数据包的最大数据长度，0 表示协议版本的上限
+}
+
+// NewDecoder 创建解码器，r 为 nil 时使用 Feed 投喂数据
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: r}
+}
+
+// SetMaxDataLen 限制单个数据包的数据长度，不超过协议版本的上限 MaxDataLenOf，n 不大于 0 时只受协议版本上限限制
+func (d *Decoder) SetMaxDataLen(n int) {
+	if n < 0 {
+		n = 0
+	}
+	d.maxDataLen = n
+}
+
+// Feed 追加待解码的数据
+func (d *Decoder) Feed(p []byte) {
+	d.buf = append(d.buf, p...)
+}
+
+// Buffered 缓存中尚未解码的字节数
+func (d *Decoder) Buffered() int {
+	return len(d.buf)
+}
+
+// Skipped 定位 Magic Number 时跳过的无效字节数
+func (d *Decoder) Skipped() int64 {
+	return d.skipped
+}
+
+// Decode 解码下一个数据包。
+// 数据流结束时返回 io.EOF（或 io.Reader 的读取错误），结束前残留不完整数据帧时先返回 ErrTruncated
+func (d *Decoder) Decode() (*DataPacket, error) {
+	for {
+		packet, ok, err := d.next()
+		if ok {
+			return packet, err
+		}
+
+		// 缓存中没有完整数据帧
+		if d.r == nil {
+			return nil, io.EOF
+		}
+		if d.err != nil {
+			return nil, d.truncated()
+		}
+		d.fill()
+	}
+}
+
+// next 尝试从缓存中解码一个数据帧，缓存数据不足时 ok 为 false
+func (d *Decoder) next() (packet *DataPacket, ok bool, err error) {
+	// 查找Magic Number
+	magicIndex := bytes.Index(d.buf, magicBytes)
+	if magicIndex == -1 {
+		// 保留最后一个字节，可能是被截断的 Magic Number
+		if len(d.buf) > 1 {
+			d.skip(len(d.buf) - 1)
+		}
+		return nil, false, nil
+	}
+	d.skip(magicIndex)
+
+	if len(d.buf) < MinPacketSize {
+		return nil, false, nil
+	}
+
+	// 验证版本，v1 与 v2 头部长度不同
+	version := d.buf[2]
+	if !IsSupportedVersion(version) {
+		frameErr := &FrameError{Err: ErrBadVersion, Header: parseHeader(d.buf), Offset: d.offset}
+		d.consume(1)
+		return nil, true, frameErr
+	}
+	headerSize := HeaderSizeOf(version)
+	if len(d.buf) < headerSize+CRCSize {
+		return nil, false, nil
+	}
+	header := parseHeader(d.buf)
+	maxDataLen := MaxDataLenOf(version)
+	if d.maxDataLen > 0 && d.maxDataLen < maxDataLen {
+		maxDataLen = d.maxDataLen
+	}
+	if int(header.DataLen) > maxDataLen {
+		frameErr := &FrameError{Err: ErrDataTooLong, Header: header, Offset: d.offset}
+		d.consume(1)
+		return nil, true, frameErr
+	}
+
+	totalLen := headerSize + int(header.DataLen) + CRCSize
+	if len(d.buf) < totalLen {
+		return nil, false, nil
+	}
+
+	// 验证CRC并解析数据包
+	packet = &DataPacket{}
+	if err = packet.UnmarshalBinary(d.buf[:totalLen]); err != nil {
+		frameErr := &FrameError{Err: err, Header: header, Offset: d.offset}
+		d.consume(1)
+		return nil, true, frameErr
+	}
+	d.consume(totalLen)
+	return packet, true, nil
+}
+
+// fill 从 io.Reader 读取更多数据
+func (d *Decoder) fill() {
+	buf := make([]byte, readSize)
+	n, err := d.r.Read(buf)
+	d.buf = append(d.buf, buf[:n]...)
+	if err != nil {
+		d.err = err
+	}
+}
+
+// truncated 数据流已结束，残留数据帧时返回 ErrTruncated 并清空缓存，否则返回读取错误
+func (d *Decoder) truncated() error {
+	if bytes.HasPrefix(d.buf, magicBytes) {
+		frameErr := &FrameError{Err: ErrTruncated, Offset: d.offset}
+		if len(d.buf) > 2 && len(d.buf) >= HeaderSizeOf(d.buf[2]) {
+			frameErr.Header = parseHeader(d.buf)
+		}
+		d.consume(len(d.buf))
+		return frameErr
+	}
+	d.skip(len(d.buf))
+	return d.err
+}
+
+// skip 跳过无效字节
+func (d *Decoder) skip(n int) {
+	d.skipped += int64(n)
+	d.consume(n)
+}
+
+// consume 移除已处理的数据
+func (d *Decoder) consume(n int) {
+	d.buf = d.buf[n:]
+	d.offset += int64(n)
+	if len(d.buf) == 0 {
+		d.buf = nil
+	}
+}
@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+// decodeAll 解码缓存中的所有数据包，返回数据包与解码错误
+func decodeAll(d *Decoder) ([]*DataPacket, []error) {
+	var packets []*DataPacket
+	var errs []error
+	for {
+		packet, err := d.Decode()
+		if err == io.EOF {
+			return packets, errs
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		packets = append(packets, packet)
+	}
+}
+
+func mustMarshal(t testing.TB, p DataPacket) []byte {
+	t.Helper()
+	data, err := p.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDecoderResync(t *testing.T) {
+	v1 := mustMarshal(t, DataPacket{Header: PacketHeader{SeqNum: 1, PacketType: 1, Status: byte(DataEnd), TaskID: 3}, Data: []byte("hello")})
+	v2 := mustMarshal(t, DataPacket{Header: PacketHeader{Version: ProtocolVersion2, SeqNum: 2, PacketType: 1, Status: byte(DataEnd), TaskID: 300}, Data: []byte("world")})
+	corrupt := append([]byte(nil), v1...)
+	corrupt[len(corrupt)-1] ^= 0xFF
+
+	var stream []byte
+	stream = append(stream, "noise"...)
+	stream = append(stream, corrupt...)
+	stream = append(stream, v1...)
+	stream = append(stream, 0xCA, 0x00)
+	stream = append(stream, v2...)
+
+	// 逐字节投喂与一次投喂的结果一致
+	for _, chunk := range []int{1, 7, len(stream)} {
+		d := NewDecoder(nil)
+		var packets []*DataPacket
+		var errs []error
+		for i := 0; i < len(stream); i += chunk {
+			end := i + chunk
+			if end > len(stream) {
+				end = len(stream)
+			}
+			d.Feed(stream[i:end])
+			p, e := decodeAll(d)
+			packets, errs = append(packets, p...), append(errs, e...)
+		}
+		if len(packets) != 2 || string(packets[0].Data) != "hello" || string(packets[1].Data) != "world" || packets[1].Header.TaskID != 300 {
+			t.Fatalf("chunk %d: got %d packets", chunk, len(packets))
+		}
+		if len(errs) != 1 || !errors.Is(errs[0], ErrBadCRC) {
+			t.Errorf("chunk %d: got errors %v, want one ErrBadCRC", chunk, errs)
+		}
+	}
+}
+
+func TestDecoderTruncated(t *testing.T) {
+	v1 := mustMarshal(t, DataPacket{Header: PacketHeader{SeqNum: 1, Status: byte(DataEnd)}, Data: []byte("hello")})
+	d := NewDecoder(bytes.NewReader(v1[:len(v1)-2]))
+	if _, err := d.Decode(); !errors.Is(err, ErrTruncated) {
+		t.Fatalf("got %v, want ErrTruncated", err)
+	}
+	if _, err := d.Decode(); err != io.EOF {
+		t.Fatalf("got %v, want io.EOF", err)
+	}
+}
+
+func FuzzDecoder(f *testing.F) {
+	f.Add(mustMarshal(f, DataPacket{Header: PacketHeader{SeqNum: 1, Status: byte(DataEnd), TaskID: 3}, Data: []byte("hello")}))
+	f.Add(mustMarshal(f, DataPacket{Header: PacketHeader{Version: ProtocolVersion2, Flags: FlagCompressed, TaskID: 300, Status: byte(DataStart)}, Data: []byte("world")}))
+	f.Add([]byte{0xCA, 0xFE, 0x02, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xFF, 0xFF, 0xFF, 0xFF})
+	f.Add([]byte("\xca\xca\xfe\x01"))
+
+	f.Fuzz(func(t *testing.T, stream []byte) {
+		d := NewDecoder(nil)
+		d.SetMaxDataLen(4096)
+		d.Feed(stream)
+		packets, _ := decodeAll(d)
+		for _, packet := range packets {
+			// 解码出的数据包重新编码后必须出现在输入中，编码与解码不能不一致
+			frame, err := packet.MarshalBinary()
+			if err != nil {
+				t.Fatalf("decoded packet does not marshal: %v", err)
+			}
+			if !bytes.Contains(stream, frame) {
+				t.Fatalf("re-encoded frame %x not in input", frame)
+			}
+			if len(packet.Data) > 4096 {
+				t.Fatalf("data length %d exceeds limit", len(packet.Data))
+			}
+		}
+		// 未解码的数据只能是不完整的帧
+		if d.Buffered() > len(stream) {
+			t.Fatalf("buffered %d of %d bytes", d.Buffered(), len(stream))
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// 编码错误
+var (
+	ErrBadMagic     = errors.New("数据包Magic Number错误")
+	ErrBadLength    = errors.New("数据包长度与头部不一致")
+	ErrDataTooLong  = errors.New("数据包数据超过最大长度")
+	ErrTaskIDTooBig = errors.New("任务ID超出协议版本范围")
+)
+
+// 单个数据包的最大数据长度：v1 受 16 位长度字段限制；
+// v2 长度字段为 32 位，为避免异常长度占用内存，解码时限制为 MaxDataLenV2
+const (
+	MaxDataLen   = math.MaxUint16
+	MaxDataLenV2 = 16 << 20
+)
+
+// MaxDataLenOf 协议版本对应的单个数据包最大数据长度
+func MaxDataLenOf(version byte) int {
+	if version == ProtocolVersion2 {
+		return MaxDataLenV2
+	}
+	return MaxDataLen
+}
+
+// MaxTaskIDOf 协议版本对应的最大任务ID
+func MaxTaskIDOf(version byte) int {
+	if version == ProtocolVersion2 {
+		return math.MaxUint16
+	}
+	return math.MaxUint8
+}
+
+// MarshalBinary 按 0xCAFE 协议编码数据包，是协议线格式的唯一定义：
+//
+//	v1:
+//	0       2         3        7            8        9        10        12
+//	+-------+---------+--------+------------+--------+--------+---------+------+-------+
+//	| Magic | Version | SeqNum | PacketType | Status | TaskID | DataLen | Data | CRC32 |
+//	+-------+---------+--------+------------+--------+--------+---------+------+-------+
+//
+//	v2:
+//	0       2         3        7            8        9       10       12        16
+//	+-------+---------+--------+------------+--------+-------+--------+---------+------+-------+
+//	| Magic | Version | SeqNum | PacketType | Status | Flags | TaskID | DataLen | Data | CRC32 |
+//	+-------+---------+--------+------------+--------+-------+--------+---------+------+-------+
+//
+// 多字节字段均为大端序，CRC32（IEEE）覆盖头部与数据。
+// Magic Number 固定为 MagicNumber，Version 为 0 时使用 ProtocolVersion，
+// DataLen 与 CRC32 以编码时的 Data 为准，忽略结构体中的值
+func (p DataPacket) MarshalBinary() ([]byte, error) {
+	version := p.Header.Version
+	if version == 0 {
+		version = ProtocolVersion
+	}
+	if !IsSupportedVersion(version) {
+		return nil, ErrBadVersion
+	}
+	if len(p.Data) > MaxDataLenOf(version) {
+		return nil, ErrDataTooLong
+	}
+	if int(p.Header.TaskID) > MaxTaskIDOf(version) {
+		return nil, ErrTaskIDTooBig
+	}
+
+	headerSize := HeaderSizeOf(version)
+	packet := make([]byte, headerSize+len(p.Data)+CRCSize)
+	putHeader(packet, p.Header, version, len(p.Data))
+	copy(packet[headerSize:], p.Data)
+
+	crc := crc32.ChecksumIEEE(packet[:headerSize+len(p.Data)])
+	binary.BigEndian.PutUint32(packet[headerSize+len(p.Data):], crc)
+	return packet, nil
+}
+
+// putHeader 按协议版本编码头部，b 至少包含对应版本的头部长度
+func putHeader(b []byte, header PacketHeader, version byte, dataLen int) {
+	binary.BigEndian.PutUint16(b[0:], MagicNumber)
+	b[2] = version
+	binary.BigEndian.PutUint32(b[3:], header.SeqNum)
+	b[7] = header.PacketType
+	b[8] = header.Status
+	if version == ProtocolVersion2 {
+		b[9] = header.Flags
+		binary.BigEndian.PutUint16(b[10:], header.TaskID)
+		binary.BigEndian.PutUint32(b[12:], uint32(dataLen))
+		return
+	}
+	b[9] = byte(header.TaskID)
+	binary.BigEndian.PutUint16(b[10:], uint16(dataLen))
+}
+
+// UnmarshalBinary 解码一个完整的 v1 或 v2 数据帧，是 MarshalBinary 的逆操作，
+// data 长度必须与头部 DataLen 一致，并校验 Magic Number、版本与CRC
+func (p *DataPacket) UnmarshalBinary(data []byte) error {
+	if len(data) < MinPacketSize {
+		return ErrTruncated
+	}
+	if binary.BigEndian.Uint16(data) != MagicNumber {
+		return ErrBadMagic
+	}
+	if !IsSupportedVersion(data[2]) {
+		return ErrBadVersion
+	}
+	headerSize := HeaderSizeOf(data[2])
+	if len(data) < headerSize+CRCSize {
+		return ErrTruncated
+	}
+	header := parseHeader(data)
+	if int(header.DataLen) > MaxDataLenOf(header.Version) {
+		return ErrDataTooLong
+	}
+	totalLen := headerSize + int(header.DataLen) + CRCSize
+	if len(data) < totalLen {
+		return ErrTruncated
+	}
+	if len(data) > totalLen {
+		return ErrBadLength
+	}
+
+	expectedCRC := binary.BigEndian.Uint32(data[totalLen-CRCSize:])
+	if crc32.ChecksumIEEE(data[:totalLen-CRCSize]) != expectedCRC {
+		return ErrBadCRC
+	}
+
+	p.Header = header
+	p.Data = append([]byte(nil), data[headerSize:totalLen-CRCSize]...)
+	p.CRC32 = expectedCRC
+	return nil
+}
+
+// Encoder 0xCAFE 协议编码器，将数据包逐帧写入 io.Writer
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder 创建编码器
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode 编码数据包并写入，每个数据包一次 Write 调用
+func (e *Encoder) Encode(p *DataPacket) error {
+	packet, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = e.w.Write(packet)
+	return err
+}
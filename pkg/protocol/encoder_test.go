@@ -0,0 +1,99 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestDataPacketRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		header PacketHeader
+		data   []byte
+		size   int // 编码后的头部长度
+	}{
+		{name: "v1 empty", header: PacketHeader{Version: ProtocolVersion, Status: byte(DataEnd)}, size: HeaderSize},
+		{name: "v1 default version", header: PacketHeader{SeqNum: 7, PacketType: 3, Status: byte(DataEnd), TaskID: 255}, data: []byte("abc"), size: HeaderSize},
+		{name: "v1 max", header: PacketHeader{Version: ProtocolVersion, SeqNum: 0xFFFFFFFF, PacketType: 0xA0, Status: byte(DataTransfer), TaskID: 1}, data: bytes.Repeat([]byte{1}, MaxDataLen), size: HeaderSize},
+		{name: "v2", header: PacketHeader{Version: ProtocolVersion2, SeqNum: 1 << 31, PacketType: 1, Status: byte(DataStart), Flags: FlagCompressed, TaskID: 0xFFFF}, data: []byte("v2 data"), size: HeaderSizeV2},
+		{name: "v2 large", header: PacketHeader{Version: ProtocolVersion2, Status: byte(DataEnd), Flags: FlagCompressed | FlagEncrypted, TaskID: 256}, data: bytes.Repeat([]byte{2}, MaxDataLen+1), size: HeaderSizeV2},
+	}
+	for _, tt := range tests {
+		encoded, err := DataPacket{Header: tt.header, Data: tt.data}.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(encoded) != tt.size+len(tt.data)+CRCSize {
+			t.Errorf("%s: encoded %d bytes, want %d", tt.name, len(encoded), tt.size+len(tt.data)+CRCSize)
+		}
+
+		var decoded DataPacket
+		if err = decoded.UnmarshalBinary(encoded); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		want := tt.header
+		want.MagicNumber = MagicNumber
+		if want.Version == 0 {
+			want.Version = ProtocolVersion
+		}
+		want.DataLen = uint32(len(tt.data))
+		if decoded.Header != want {
+			t.Errorf("%s: got header %+v, want %+v", tt.name, decoded.Header, want)
+		}
+		if !bytes.Equal(decoded.Data, tt.data) {
+			t.Errorf("%s: data mismatch", tt.name)
+		}
+
+		// 再次编码得到相同的字节
+		again, err := decoded.MarshalBinary()
+		if err != nil || !bytes.Equal(again, encoded) {
+			t.Errorf("%s: re-encoded frame differs", tt.name)
+		}
+	}
+}
+
+func TestDataPacketMarshalErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet DataPacket
+		err    error
+	}{
+		{name: "bad version", packet: DataPacket{Header: PacketHeader{Version: 3}}, err: ErrBadVersion},
+		{name: "v1 data too long", packet: DataPacket{Data: make([]byte, MaxDataLen+1)}, err: ErrDataTooLong},
+		{name: "v1 task id", packet: DataPacket{Header: PacketHeader{TaskID: 256}}, err: ErrTaskIDTooBig},
+	}
+	for _, tt := range tests {
+		if _, err := tt.packet.MarshalBinary(); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestDataPacketUnmarshalErrors(t *testing.T) {
+	valid, err := DataPacket{Header: PacketHeader{Version: ProtocolVersion2, Status: byte(DataEnd)}, Data: []byte("abc")}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify := func(fn func(b []byte) []byte) []byte {
+		return fn(append([]byte(nil), valid...))
+	}
+	tests := []struct {
+		name string
+		data []byte
+		err  error
+	}{
+		{name: "short", data: valid[:MinPacketSize-1], err: ErrTruncated},
+		{name: "magic", data: modify(func(b []byte) []byte { b[0] = 0; return b }), err: ErrBadMagic},
+		{name: "version", data: modify(func(b []byte) []byte { b[2] = 9; return b }), err: ErrBadVersion},
+		{name: "truncated data", data: valid[:len(valid)-1], err: ErrTruncated},
+		{name: "trailing bytes", data: append(append([]byte(nil), valid...), 0), err: ErrBadLength},
+		{name: "crc", data: modify(func(b []byte) []byte { b[HeaderSizeV2] ^= 1; return b }), err: ErrBadCRC},
+	}
+	for _, tt := range tests {
+		var p DataPacket
+		if err := p.UnmarshalBinary(tt.data); !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
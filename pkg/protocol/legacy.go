@@ -0,0 +1,224 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// 旧版通道（.fa 任务通道、.fa2 数据采集通道）定长帧协议常量
+const (
+	LegacyFrameSize     = 316                                // 定长帧长度
+	LegacyHeaderSize    = 6                                  // 定长帧头部长度
+	LegacyMaxPayload    = LegacyFrameSize - LegacyHeaderSize // 单帧最大数据长度
+	LegacyMaxMessageLen = 1<<24 - 1                          // 24 位长度字段可表示的最大数据长度
+	LegacyShortHeader   = 3                                  // 短帧头部长度
+	legacyMinTaskID     = 1                                  // 有效任务ID下限
+	legacyMaxTaskID     = 254                                // 有效任务ID上限
+)
+
+// ErrLegacyFrame 旧版定长帧格式错误
+var ErrLegacyFrame = errors.New("旧版数据帧格式错误")
+
+// LegacyFrame 旧版定长帧，一条完整数据按 DataStart 的分帧规则拆分为若干定长帧：
+//
+//	0            1        2        3                  6                     316
+//	+------------+--------+--------+------------------+------+--------------+
+//	| PacketType | TaskID | Status | TotalLen（24位） | Data | 0 填充        |
+//	+------------+--------+--------+------------------+------+--------------+
+//
+// TotalLen 为整条数据的长度（大端序），每帧相同；每帧最多携带 LegacyMaxPayload 字节，
+// 帧内有效数据长度由 TotalLen 与已接收长度推算，见 Chunk。TaskID 取值 1~254
+type LegacyFrame struct {
+	PacketType byte
+	TaskID     byte
+	Status     byte
+	TotalLen   uint32 // 整条数据长度
+	Data       []byte // 帧数据区，解码时固定为 LegacyMaxPayload 字节（含填充）
+}
+
+// MarshalBinary 编码为 LegacyFrameSize 字节的定长帧，Data 不足时以 0 填充
+func (f LegacyFrame) MarshalBinary() ([]byte, error) {
+	if err := f.validate(); err != nil {
+		return nil, err
+	}
+	if len(f.Data) > LegacyMaxPayload {
+		return nil, ErrDataTooLong
+	}
+	frame := make([]byte, LegacyFrameSize)
+	frame[0] = f.PacketType
+	frame[1] = f.TaskID
+	frame[2] = f.Status
+	putUint24(frame[3:], f.TotalLen)
+	copy(frame[LegacyHeaderSize:], f.Data)
+	return frame, nil
+}
+
+// UnmarshalBinary 解码一个定长帧，data 长度必须为 LegacyFrameSize，任务ID、状态或长度无效时返回 ErrLegacyFrame
+func (f *LegacyFrame) UnmarshalBinary(data []byte) error {
+	if len(data) < LegacyFrameSize {
+		return ErrTruncated
+	}
+	if len(data) > LegacyFrameSize {
+		return ErrBadLength
+	}
+	frame := LegacyFrame{
+		PacketType: data[0],
+		TaskID:     data[1],
+		Status:     data[2],
+		TotalLen:   uint24(data[3:]),
+		Data:       append([]byte(nil), data[LegacyHeaderSize:]...),
+	}
+	if err := frame.validate(); err != nil {
+		return err
+	}
+	*f = frame
+	return nil
+}
+
+// Chunk 在已接收 received 字节的情况下，本帧携带的有效数据
+func (f LegacyFrame) Chunk(received int) []byte {
+	remaining := int(f.TotalLen) - received
+	if remaining <= 0 {
+		return nil
+	}
+	if remaining > len(f.Data) {
+		remaining = len(f.Data)
+	}
+	return f.Data[:remaining]
+}
+
+// validate 校验任务ID、状态与长度
+func (f LegacyFrame) validate() error {
+	if f.TaskID < legacyMinTaskID || f.TaskID > legacyMaxTaskID {
+		return ErrLegacyFrame
+	}
+	switch int(f.Status) {
+	case DataStart, DataTransfer, DataEnd:
+	default:
+		return ErrLegacyFrame
+	}
+	if f.TotalLen > LegacyMaxMessageLen {
+		return ErrLegacyFrame
+	}
+	return nil
+}
+
+// IsLegacyFrameHeader b 的前 3 字节是否像定长帧头部（任务ID与状态有效），
+// 用于在数据采集通道区分定长帧与短帧
+func IsLegacyFrameHeader(b []byte) bool {
+	if len(b) < 3 {
+		return false
+	}
+	return LegacyFrame{TaskID: b[1], Status: b[2]}.validate() == nil
+}
+
+// LegacyShortFrame 旧版探针的短帧，不分片：
+//
+//	+------------+----------------+------+
+//	| PacketType | Length（16位） | Data |
+//	+------------+----------------+------+
+//
+// 数据采集通道（.fa2）的旧版心跳数据按实际长度发送；
+// 任务通道（.fa）的旧版业务回调数据以 0 填充为 LegacyFrameSize 字节
+type LegacyShortFrame struct {
+	PacketType byte
+	Data       []byte
+}
+
+// DecodeLegacyShortFrame 从 b 开头解码一个短帧，返回短帧与消耗的字节数，数据不完整时返回 ErrTruncated
+func DecodeLegacyShortFrame(b []byte) (LegacyShortFrame, int, error) {
+	if len(b) < LegacyShortHeader {
+		return LegacyShortFrame{}, 0, ErrTruncated
+	}
+	n := LegacyShortHeader + int(binary.BigEndian.Uint16(b[1:]))
+	if len(b) < n {
+		return LegacyShortFrame{}, 0, ErrTruncated
+	}
+	return LegacyShortFrame{PacketType: b[0], Data: append([]byte(nil), b[LegacyShortHeader:n]...)}, n, nil
+}
+
+// DecodePaddedLegacyShortFrame 解码填充为 LegacyFrameSize 字节的短帧，b 长度必须为 LegacyFrameSize
+func DecodePaddedLegacyShortFrame(b []byte) (LegacyShortFrame, error) {
+	if len(b) != LegacyFrameSize {
+		return LegacyShortFrame{}, ErrBadLength
+	}
+	frame, _, err := DecodeLegacyShortFrame(b)
+	if err == ErrTruncated {
+		// 长度字段超出定长帧
+		return LegacyShortFrame{}, ErrLegacyFrame
+	}
+	return frame, err
+}
+
+// MarshalBinary 编码短帧，数据超过 16 位长度时返回 ErrDataTooLong
+func (f LegacyShortFrame) MarshalBinary() ([]byte, error) {
+	if len(f.Data) > MaxDataLen {
+		return nil, ErrDataTooLong
+	}
+	frame := make([]byte, LegacyShortHeader+len(f.Data))
+	frame[0] = f.PacketType
+	binary.BigEndian.PutUint16(frame[1:], uint16(len(f.Data)))
+	copy(frame[LegacyShortHeader:], f.Data)
+	return frame, nil
+}
+
+// LegacyEncoder 旧版定长帧编码器，将一条完整数据按分帧规则拆分为定长帧写入 io.Writer
+type LegacyEncoder struct {
+	w io.Writer
+}
+
+// NewLegacyEncoder 创建旧版定长帧编码器
+func NewLegacyEncoder(w io.Writer) *LegacyEncoder {
+	return &LegacyEncoder{w: w}
+}
+
+// Encode 分帧写入一条完整数据，每帧一次 Write 调用；不超过 LegacyMaxPayload 的数据只发送一帧 DataEnd
+func (e *LegacyEncoder) Encode(packetType, taskID byte, data []byte) error {
+	if len(data) > LegacyMaxMessageLen {
+		return ErrDataTooLong
+	}
+	count := (len(data) + LegacyMaxPayload - 1) / LegacyMaxPayload
+	if count == 0 {
+		count = 1
+	}
+	for i := 0; i < count; i++ {
+		end := (i + 1) * LegacyMaxPayload
+		if end > len(data) {
+			end = len(data)
+		}
+		status := DataTransfer
+		switch {
+		case i == count-1:
+			status = DataEnd
+		case i == 0:
+			status = DataStart
+		}
+		frame, err := LegacyFrame{
+			PacketType: packetType,
+			TaskID:     taskID,
+			Status:     byte(status),
+			TotalLen:   uint32(len(data)),
+			Data:       data[i*LegacyMaxPayload : end],
+		}.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if _, err = e.w.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// uint24 解码 24 位大端序整数
+func uint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
+
+// putUint24 编码 24 位大端序整数
+func putUint24(b []byte, v uint32) {
+	b[0] = byte(v >> 16)
+	b[1] = byte(v >> 8)
+	b[2] = byte(v)
+}
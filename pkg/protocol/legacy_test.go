@@ -0,0 +1,137 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// legacyRecorder 按 Write 调用记录编码器写入的每一帧
+type legacyRecorder struct {
+	frames [][]byte
+}
+
+func (r *legacyRecorder) Write(p []byte) (int, error) {
+	r.frames = append(r.frames, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+func TestLegacyEncoderRoundTrip(t *testing.T) {
+	tests := []struct {
+		size     int
+		statuses []int
+	}{
+		{size: 0, statuses: []int{DataEnd}},
+		{size: LegacyMaxPayload, statuses: []int{DataEnd}},
+		{size: LegacyMaxPayload + 1, statuses: []int{DataStart, DataEnd}},
+		{size: 3*LegacyMaxPayload - 5, statuses: []int{DataStart, DataTransfer, DataEnd}},
+	}
+	for _, tt := range tests {
+		data := make([]byte, tt.size)
+		for i := range data {
+			data[i] = byte(i*13 + 1)
+		}
+		rec := &legacyRecorder{}
+		if err := NewLegacyEncoder(rec).Encode(2, 7, data); err != nil {
+			t.Fatalf("size %d: %v", tt.size, err)
+		}
+		if len(rec.frames) != len(tt.statuses) {
+			t.Fatalf("size %d: got %d frames, want %d", tt.size, len(rec.frames), len(tt.statuses))
+		}
+		var got []byte
+		for i, raw := range rec.frames {
+			if len(raw) != LegacyFrameSize {
+				t.Fatalf("size %d: frame %d is %d bytes", tt.size, i, len(raw))
+			}
+			var frame LegacyFrame
+			if err := frame.UnmarshalBinary(raw); err != nil {
+				t.Fatalf("size %d: frame %d: %v", tt.size, i, err)
+			}
+			if frame.PacketType != 2 || frame.TaskID != 7 || int(frame.Status) != tt.statuses[i] || int(frame.TotalLen) != tt.size {
+				t.Errorf("size %d: frame %d header %+v", tt.size, i, frame)
+			}
+			got = append(got, frame.Chunk(len(got))...)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("size %d: reassembled data differs", tt.size)
+		}
+	}
+}
+
+func TestLegacyFrameErrors(t *testing.T) {
+	valid, err := LegacyFrame{PacketType: 1, TaskID: 1, Status: byte(DataEnd)}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{name: "truncated", data: valid[:LegacyFrameSize-1], want: ErrTruncated},
+		{name: "too long", data: append(append([]byte(nil), valid...), 0), want: ErrBadLength},
+		{name: "task id 0", data: withByte(valid, 1, 0), want: ErrLegacyFrame},
+		{name: "task id 255", data: withByte(valid, 1, 255), want: ErrLegacyFrame},
+		{name: "bad status", data: withByte(valid, 2, 3), want: ErrLegacyFrame},
+	}
+	for _, tt := range tests {
+		var frame LegacyFrame
+		if err := frame.UnmarshalBinary(tt.data); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+	if _, err = (LegacyFrame{TaskID: 1, Data: make([]byte, LegacyMaxPayload+1)}).MarshalBinary(); !errors.Is(err, ErrDataTooLong) {
+		t.Errorf("oversized data: got %v", err)
+	}
+}
+
+// withByte 复制 b 并修改第 i 字节
+func withByte(b []byte, i int, v byte) []byte {
+	b = append([]byte(nil), b...)
+	b[i] = v
+	return b
+}
+
+func TestLegacyShortFrame(t *testing.T) {
+	for _, size := range []int{0, 1, 256, 513, MaxDataLen} {
+		data := bytes.Repeat([]byte{'x'}, size)
+		raw, err := LegacyShortFrame{PacketType: 2, Data: data}.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err = DecodeLegacyShortFrame(raw[:len(raw)-1]); !errors.Is(err, ErrTruncated) {
+			t.Errorf("size %d truncated: got %v", size, err)
+		}
+		frame, n, err := DecodeLegacyShortFrame(append(raw, 0xFF))
+		if err != nil || n != len(raw) || frame.PacketType != 2 || !bytes.Equal(frame.Data, data) {
+			t.Errorf("size %d: got %d bytes, %v", size, n, err)
+		}
+	}
+	// 长度 256、513 的头部恰好像定长帧的任务ID与状态
+	for _, size := range []int{256, 513} {
+		raw, _ := LegacyShortFrame{PacketType: 2, Data: make([]byte, size)}.MarshalBinary()
+		if !IsLegacyFrameHeader(raw) {
+			t.Errorf("size %d: header % x not ambiguous", size, raw[:3])
+		}
+	}
+	if _, err := (LegacyShortFrame{Data: make([]byte, MaxDataLen+1)}).MarshalBinary(); !errors.Is(err, ErrDataTooLong) {
+		t.Errorf("oversized short frame: got %v", err)
+	}
+}
+
+func TestPaddedLegacyShortFrame(t *testing.T) {
+	raw, _ := LegacyShortFrame{PacketType: 3, Data: []byte("callback")}.MarshalBinary()
+	padded := make([]byte, LegacyFrameSize)
+	copy(padded, raw)
+	frame, err := DecodePaddedLegacyShortFrame(padded)
+	if err != nil || frame.PacketType != 3 || string(frame.Data) != "callback" {
+		t.Fatalf("got %+v, %v", frame, err)
+	}
+	// 长度字段超出定长帧
+	if _, err = DecodePaddedLegacyShortFrame(withByte(padded, 1, 0x02)); !errors.Is(err, ErrLegacyFrame) {
+		t.Errorf("length beyond frame: got %v", err)
+	}
+	if _, err = DecodePaddedLegacyShortFrame(padded[:10]); !errors.Is(err, ErrBadLength) {
+		t.Errorf("short input: got %v", err)
+	}
+}
@@ -1,14 +1,38 @@
 package protocol
 
-// PacketHeader 数据包头部
+import "encoding/binary"
+
+// 0xCAFE 协议常量，HeaderSize、MinPacketSize 为 v1 头部
+const (
+	MagicNumber     uint16 = 0xCAFE
+	ProtocolVersion byte   = 0x01
+	HeaderSize             = 12
+	CRCSize                = 4
+	MinPacketSize          = HeaderSize + CRCSize
+)
+
+// v2 协议常量：16 位任务ID、标志位与 32 位数据长度
+const (
+	ProtocolVersion2 byte = 0x02
+	HeaderSizeV2          = 16
+)
+
+// v2 头部标志位
+const (
+	FlagCompressed byte = 1 << iota // 数据经 gzip 压缩，见 Compress
+	FlagEncrypted                   // 数据经 AEAD 加密认证，见 Sealer
+)
+
+// PacketHeader 数据包头部，Flags 仅 v2 有效，v1 的 TaskID 与 DataLen 分别不超过 255 与 MaxDataLen
 type PacketHeader struct {
 	MagicNumber uint16
 	Version     byte
 	SeqNum      uint32
 	PacketType  byte
 	Status      byte
-	TaskID      byte
-	DataLen     uint16
+	Flags       byte
+	TaskID      uint16
+	DataLen     uint32
 }
 
 type PacketBuffer struct {
@@ -24,9 +48,50 @@ type DataPacket struct {
 	CRC32  uint32
 }
 
-// 数据状态
+// 数据状态，一条完整数据按以下规则分帧，同一条数据的各帧使用相同的任务ID且序列号连续：
+//   - 空数据：一帧 DataEnd，DataLen 为 0
+//   - 不超过单帧最大长度的数据：一帧 DataEnd
+//   - 超过单帧最大长度的数据：一帧 DataStart、零或多帧 DataTransfer、一帧 DataEnd，每帧数据均非空
+//
+// 接收方收到没有缓存的 DataEnd 视为单帧数据；收到 DataStart 时丢弃同一任务ID未完成的数据重新开始
 var (
 	DataStart    = 0
 	DataTransfer = 1
 	DataEnd      = 2
 )
+
+// SupportedVersions 本端支持的协议版本，按优先级从高到低排列
+var SupportedVersions = []byte{ProtocolVersion2, ProtocolVersion}
+
+// IsSupportedVersion 是否为支持的协议版本
+func IsSupportedVersion(version byte) bool {
+	return version == ProtocolVersion || version == ProtocolVersion2
+}
+
+// HeaderSizeOf 协议版本对应的头部长度
+func HeaderSizeOf(version byte) int {
+	if version == ProtocolVersion2 {
+		return HeaderSizeV2
+	}
+	return HeaderSize
+}
+
+// parseHeader 解析数据包头部，b 至少包含对应版本的头部长度（HeaderSizeOf(b[2])）
+func parseHeader(b []byte) PacketHeader {
+	header := PacketHeader{
+		MagicNumber: binary.BigEndian.Uint16(b[0:]),
+		Version:     b[2],
+		SeqNum:      binary.BigEndian.Uint32(b[3:]),
+		PacketType:  b[7],
+		Status:      b[8],
+	}
+	if header.Version == ProtocolVersion2 {
+		header.Flags = b[9]
+		header.TaskID = binary.BigEndian.Uint16(b[10:])
+		header.DataLen = binary.BigEndian.Uint32(b[12:])
+		return header
+	}
+	header.TaskID = uint16(b[9])
+	header.DataLen = uint32(binary.BigEndian.Uint16(b[10:]))
+	return header
+}
@@ -0,0 +1,47 @@
+package protocol
+
+// ReplayWindowSize 重放窗口记录的序列号数量，落后最大序列号超过该数量的数据包视为重放
+const ReplayWindowSize = 64
+
+// ReplayWindow 按序列号检测重放的滑动窗口，记录已接收的最大序列号与其之前 ReplayWindowSize 个序列号，
+// 允许窗口内乱序到达；序列号按 uint32 回绕比较（相差不超过 2^31 时较大者为新），
+// 因此从 0xFFFFFFFF 回绕到 0 的数据包被视为新数据包。零值可直接使用，非并发安全
+type ReplayWindow struct {
+	started bool
+	highest uint32
+	bitmap  uint64 // 第 i 位表示 highest-i 已接收
+}
+
+// Check 检查序列号是否重放，未重放时记录该序列号：窗口内已接收的序列号返回 ErrReplayed，
+// 落后超过窗口的序列号返回 ErrOutOfWindow；加密时应在数据包认证通过后调用
+func (w *ReplayWindow) Check(seqNum uint32) error {
+	if !w.started {
+		w.started, w.highest, w.bitmap = true, seqNum, 1
+		return nil
+	}
+	diff := int32(seqNum - w.highest)
+	if diff > 0 {
+		if diff >= ReplayWindowSize {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= uint(diff)
+		}
+		w.highest = seqNum
+		w.bitmap |= 1
+		return nil
+	}
+	back := uint32(-diff)
+	if back >= ReplayWindowSize {
+		return ErrOutOfWindow
+	}
+	if w.bitmap&(1<<back) != 0 {
+		return ErrReplayed
+	}
+	w.bitmap |= 1 << back
+	return nil
+}
+
+// Reset 清空窗口，更换密钥或对端重新开始计数时调用
+func (w *ReplayWindow) Reset() {
+	*w = ReplayWindow{}
+}
@@ -0,0 +1,64 @@
+package protocol
+
+import (
+	"errors"
+	"testing"
+)
+
+// checkSeqs 依次检查序列号，返回各自的结果
+func checkSeqs(w *ReplayWindow, seqs ...uint32) []error {
+	errs := make([]error, len(seqs))
+	for i, seq := range seqs {
+		errs[i] = w.Check(seq)
+	}
+	return errs
+}
+
+func TestReplayWindow(t *testing.T) {
+	tests := []struct {
+		name  string
+		start []uint32 // 先接收的序列号，均应通过
+		seq   uint32
+		want  error
+	}{
+		{name: "first", seq: 100},
+		{name: "next", start: []uint32{100}, seq: 101},
+		{name: "out of order", start: []uint32{100, 105}, seq: 103},
+		{name: "duplicate highest", start: []uint32{100, 105}, seq: 105, want: ErrReplayed},
+		{name: "duplicate in window", start: []uint32{100, 105, 103}, seq: 103, want: ErrReplayed},
+		{name: "duplicate out of order", start: []uint32{105, 100}, seq: 100, want: ErrReplayed},
+		{name: "oldest in window", start: []uint32{200}, seq: 200 - ReplayWindowSize + 1},
+		{name: "behind window", start: []uint32{200}, seq: 200 - ReplayWindowSize, want: ErrOutOfWindow},
+		{name: "shift within window", start: []uint32{100, 100 + ReplayWindowSize - 1}, seq: 100, want: ErrReplayed},
+		{name: "shift past window", start: []uint32{100, 100 + ReplayWindowSize}, seq: 100, want: ErrOutOfWindow},
+		{name: "large shift clears bitmap", start: []uint32{100, 1000}, seq: 999},
+		{name: "large shift keeps highest", start: []uint32{100, 1000}, seq: 1000, want: ErrReplayed},
+		{name: "wraparound", start: []uint32{0xFFFFFFFE, 0xFFFFFFFF}, seq: 0},
+		{name: "wraparound out of order", start: []uint32{0xFFFFFFF0, 2}, seq: 0xFFFFFFFA},
+		{name: "wraparound duplicate", start: []uint32{0xFFFFFFFF, 1}, seq: 0xFFFFFFFF, want: ErrReplayed},
+		{name: "wraparound behind window", start: []uint32{0xFFFFFFF0, ReplayWindowSize}, seq: 0xFFFFFFF0, want: ErrOutOfWindow},
+	}
+	for _, tt := range tests {
+		var w ReplayWindow
+		for i, err := range checkSeqs(&w, tt.start...) {
+			if err != nil {
+				t.Fatalf("%s: start seq %d: %v", tt.name, tt.start[i], err)
+			}
+		}
+		if err := w.Check(tt.seq); !errors.Is(err, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+		}
+	}
+}
+
+func TestReplayWindowReset(t *testing.T) {
+	var w ReplayWindow
+	if errs := checkSeqs(&w, 10, 11, 11); errs[2] != ErrReplayed {
+		t.Fatalf("got %v, want replay before reset", errs[2])
+	}
+	w.Reset()
+	// 重置后任意序列号重新开始
+	if errs := checkSeqs(&w, 11, 0, 11); errs[0] != nil || errs[1] != nil || errs[2] != ErrReplayed {
+		t.Errorf("after reset got %v", errs)
+	}
+}
@@ -0,0 +1,291 @@
+package protocol
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// 加密认证错误
+var (
+	ErrNotEncrypted = errors.New("数据包未加密")
+	ErrAuthFailed   = errors.New("数据包认证失败")
+	ErrReplayed     = errors.New("数据包重放")
+	ErrOutOfWindow  = errors.New("数据包序列号超出重放窗口")
+	ErrNotKeyed     = errors.New("尚未与对端握手交换随机数，无法加密认证")
+	ErrBadSalt      = errors.New("握手随机数无效")
+)
+
+// Role 通信方角色，双方使用同一预共享密钥，角色写入 nonce 以区分两个方向的序列号空间
+type Role byte
+
+const (
+	RoleHost  Role = 0x01 // 宿主机（SDK）
+	RoleProbe Role = 0x02 // 探针
+)
+
+// peer 对端角色
+func (r Role) peer() Role {
+	if r == RoleHost {
+		return RoleProbe
+	}
+	return RoleHost
+}
+
+// SaltSize 握手交换的每连接随机数长度
+const SaltSize = 16
+
+// sealInfo HKDF 派生帧密钥的 info 前缀，后接发送方角色
+const sealInfo = "agent-sdk-go seal"
+
+// helloInfo HKDF 派生 Hello 认证密钥的 info
+const helloInfo = "agent-sdk-go hello"
+
+// seenSaltCount 记录的最近对端随机数个数，用于识别重放的 Hello
+const seenSaltCount = 64
+
+// Sealer 数据帧 AEAD（AES-GCM）加密认证，使用按 kvmID 预共享的密钥。
+//
+// 预共享密钥不直接加密数据帧：双方每次握手各生成 SaltSize 字节的随机数（Salt）并通过 Hello 交换，
+// 每个方向的帧密钥由 HKDF-SHA256 派生，salt 为发送方随机数与接收方随机数的拼接，info 为 sealInfo 与发送方角色。
+// 每次连接的帧密钥不同，序列号重新开始计数也不会重复使用 (key, nonce)，之前连接截获的帧无法通过认证。
+//
+// 加密帧使用 v2 头部并设置 FlagEncrypted，Data 为密文与认证标签，头部作为附加认证数据；
+// nonce 由发送方角色与序列号组成：
+//
+//	+------+-----------+--------+
+//	| Role | 0（7字节） | SeqNum |
+//	+------+-----------+--------+
+//
+// 收到对端随机数前无法加密或解密，返回 ErrNotKeyed；同一帧密钥下序列号回绕前须重新握手。
+//
+// Hello 本身不加密，由 HMAC-SHA256 认证（见 SignHello），密钥由预共享密钥经 HKDF 派生，
+// 不知道预共享密钥无法伪造 Hello 迫使对端重新握手。可并发使用
+type Sealer struct {
+	key      []byte
+	helloKey []byte
+	role     Role
+	overhead int
+
+	mu       sync.RWMutex
+	salt     []byte      // 本端随机数
+	peerSalt []byte      // 对端随机数，尚未握手时为 nil
+	seen     [][]byte    // 最近握手中对端使用过的随机数，按使用顺序循环覆盖
+	seenNext int         // seen 中下一个覆盖的位置
+	seal     cipher.AEAD // 本端发送帧的密钥
+	open     cipher.AEAD // 对端发送帧的密钥
+}
+
+// NewSealer 创建加密认证器，key 为 16、24 或 32 字节的 AES 密钥，role 为本端角色
+func NewSealer(key []byte, role Role) (*Sealer, error) {
+	if role != RoleHost && role != RoleProbe {
+		return nil, fmt.Errorf("无效的通信方角色: %d", role)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sealer{
+		key:      append([]byte(nil), key...),
+		helloKey: hkdf(key, nil, []byte(helloInfo), sha256.Size),
+		role:     role,
+		overhead: aead.Overhead(),
+	}
+	if err = s.Rekey(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Salt 本端随机数，握手时通过 Hello 发送给对端
+func (s *Sealer) Salt() []byte {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]byte(nil), s.salt...)
+}
+
+// Rekey 生成新的本端随机数并清除帧密钥，开始新的握手前调用，收到对端随机数后才能继续加密与解密
+func (s *Sealer) Rekey() error {
+	salt := make([]byte, SaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("生成握手随机数失败: %w", err)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.salt = salt
+	s.peerSalt = nil
+	s.seal = nil
+	s.open = nil
+	return nil
+}
+
+// SetPeerSalt 设置对端在 Hello 中发送的随机数并派生双方向的帧密钥
+func (s *Sealer) SetPeerSalt(peerSalt []byte) error {
+	if len(peerSalt) != SaltSize {
+		return fmt.Errorf("%w: 握手随机数长度 %d", ErrBadSalt, len(peerSalt))
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seal, err := newAEAD(s.deriveKey(s.role, s.salt, peerSalt))
+	if err != nil {
+		return err
+	}
+	open, err := newAEAD(s.deriveKey(s.role.peer(), peerSalt, s.salt))
+	if err != nil {
+		return err
+	}
+	s.peerSalt = append([]byte(nil), peerSalt...)
+	if len(s.seen) < seenSaltCount {
+		s.seen = append(s.seen, s.peerSalt)
+	} else {
+		s.seen[s.seenNext] = s.peerSalt
+		s.seenNext = (s.seenNext + 1) % seenSaltCount
+	}
+	s.seal = seal
+	s.open = open
+	return nil
+}
+
+// SignHello 为本端发送的 Hello 填入本端随机数与认证码。认证码覆盖 Hello 的全部字段与发送方角色，
+// 应答还覆盖发起方的随机数，截获的应答无法在之后的握手中重放；应答须在 SetPeerSalt 之后签名，否则返回 ErrNotKeyed
+func (s *Sealer) SignHello(h Hello) (Hello, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var initiator []byte
+	if h.Reply {
+		if s.peerSalt == nil {
+			return Hello{}, ErrNotKeyed
+		}
+		initiator = s.peerSalt
+	}
+	h.Salt = append([]byte(nil), s.salt...)
+	h.MAC = s.helloMAC(h, s.role, initiator)
+	return h, nil
+}
+
+// VerifyHello 校验对端 Hello 的认证码，须在 Rekey 或 SetPeerSalt 之前调用：应答按本端当前的随机数校验。
+// 认证失败返回 ErrAuthFailed；发起的 Hello 携带最近 seenSaltCount 次握手中对端用过的随机数时视为重放，返回 ErrReplayed
+func (s *Sealer) VerifyHello(h Hello) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var initiator []byte
+	if h.Reply {
+		initiator = s.salt
+	}
+	if !hmac.Equal(h.MAC, s.helloMAC(h, s.role.peer(), initiator)) {
+		return ErrAuthFailed
+	}
+	if !h.Reply {
+		for _, salt := range s.seen {
+			if hmac.Equal(h.Salt, salt) {
+				return ErrReplayed
+			}
+		}
+	}
+	return nil
+}
+
+// helloMAC 计算发送方 role 的 Hello 认证码，initiator 为应答对应的发起方随机数，调用方须持有 s.mu
+func (s *Sealer) helloMAC(h Hello, role Role, initiator []byte) []byte {
+	h.MAC = nil
+	mac := hmac.New(sha256.New, s.helloKey)
+	mac.Write(EncodeHello(h))
+	mac.Write([]byte{byte(role)})
+	mac.Write(initiator)
+	return mac.Sum(nil)
+}
+
+// deriveKey 派生发送方 role 的帧密钥，senderSalt 与 receiverSalt 分别为发送方与接收方的随机数
+func (s *Sealer) deriveKey(role Role, senderSalt, receiverSalt []byte) []byte {
+	salt := append(append([]byte(nil), senderSalt...), receiverSalt...)
+	return hkdf(s.key, salt, append([]byte(sealInfo), byte(role)), len(s.key))
+}
+
+// hkdf HKDF-SHA256（RFC 5869）派生 length 字节的密钥，length 不超过 255 个 SHA-256 输出长度
+func hkdf(secret, salt, info []byte, length int) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(secret)
+	prk := extract.Sum(nil)
+
+	var okm, block []byte
+	for counter := byte(1); len(okm) < length; counter++ {
+		expand := hmac.New(sha256.New, prk)
+		expand.Write(block)
+		expand.Write(info)
+		expand.Write([]byte{counter})
+		block = expand.Sum(nil)
+		okm = append(okm, block...)
+	}
+	return okm[:length]
+}
+
+// newAEAD 创建 AES-GCM
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Overhead 加密后每帧增加的数据长度
+func (s *Sealer) Overhead() int {
+	return s.overhead
+}
+
+// Seal 加密并编码数据包，固定使用 v2 头部并设置 FlagEncrypted
+func (s *Sealer) Seal(p DataPacket) ([]byte, error) {
+	header := p.Header
+	header.Version = ProtocolVersion2
+	header.Flags |= FlagEncrypted
+	dataLen := len(p.Data) + s.overhead
+	if dataLen > MaxDataLenV2 {
+		return nil, ErrDataTooLong
+	}
+	s.mu.RLock()
+	aead := s.seal
+	s.mu.RUnlock()
+	if aead == nil {
+		return nil, ErrNotKeyed
+	}
+	aad := make([]byte, HeaderSizeV2)
+	putHeader(aad, header, ProtocolVersion2, dataLen)
+	sealed := aead.Seal(nil, nonce(aead, s.role, header.SeqNum), p.Data, aad)
+	return DataPacket{Header: header, Data: sealed}.MarshalBinary()
+}
+
+// Open 校验并解密对端发送的数据包，成功后 p.Data 替换为明文；
+// 未设置 FlagEncrypted 时返回 ErrNotEncrypted，尚未握手时返回 ErrNotKeyed，认证失败时返回 ErrAuthFailed
+func (s *Sealer) Open(p *DataPacket) error {
+	if p.Header.Version != ProtocolVersion2 || p.Header.Flags&FlagEncrypted == 0 {
+		return ErrNotEncrypted
+	}
+	s.mu.RLock()
+	aead := s.open
+	s.mu.RUnlock()
+	if aead == nil {
+		return ErrNotKeyed
+	}
+	aad := make([]byte, HeaderSizeV2)
+	putHeader(aad, p.Header, ProtocolVersion2, len(p.Data))
+	plain, err := aead.Open(nil, nonce(aead, s.role.peer(), p.Header.SeqNum), p.Data, aad)
+	if err != nil {
+		return ErrAuthFailed
+	}
+	p.Data = plain
+	return nil
+}
+
+// nonce 由发送方角色与序列号组成的 nonce
+func nonce(aead cipher.AEAD, role Role, seqNum uint32) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	nonce[0] = byte(role)
+	binary.BigEndian.PutUint32(nonce[len(nonce)-4:], seqNum)
+	return nonce
+}
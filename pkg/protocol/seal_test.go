@@ -0,0 +1,91 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestHelloEncoding(t *testing.T) {
+	tests := []Hello{
+		{Versions: []byte{1}},
+		{Reply: true, Capabilities: CapReliable | CapGzip, Versions: []byte{1, 2}},
+		{Versions: []byte{1, 2}, Salt: bytes.Repeat([]byte{7}, SaltSize)},
+		{Reply: true, Versions: []byte{2}, Salt: bytes.Repeat([]byte{7}, SaltSize), MAC: bytes.Repeat([]byte{9}, 32)},
+	}
+	for _, want := range tests {
+		got, err := DecodeHello(EncodeHello(want))
+		if err != nil {
+			t.Fatalf("%+v: %v", want, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+	// 未加密时与旧版探针的格式一致
+	if got := EncodeHello(Hello{Capabilities: 1, Versions: []byte{1}}); !bytes.Equal(got, []byte{0, 0, 0, 0, 1, 1, 1}) {
+		t.Errorf("got % x", got)
+	}
+	if _, err := DecodeHello([]byte{0, 0, 0, 0, 0, 1, 1, 16, 1}); !errors.Is(err, ErrBadControl) {
+		t.Errorf("truncated salt: got %v", err)
+	}
+}
+
+func TestSealerHello(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	host, err := NewSealer(key, RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	probe, err := NewSealer(key, RoleProbe)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hello, err := host.SignHello(Hello{Versions: SupportedVersions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = probe.VerifyHello(hello); err != nil {
+		t.Fatalf("verify hello: %v", err)
+	}
+	// 篡改任意字段或反射回发送方都不能通过认证
+	tampered := hello
+	tampered.Capabilities |= CapReliable
+	if err = probe.VerifyHello(tampered); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("tampered hello: got %v", err)
+	}
+	if err = host.VerifyHello(hello); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("reflected hello: got %v", err)
+	}
+
+	// 应答须在交换随机数后签名，并绑定发起方的随机数
+	if _, err = probe.SignHello(Hello{Reply: true, Versions: SupportedVersions}); !errors.Is(err, ErrNotKeyed) {
+		t.Errorf("reply before keyed: got %v", err)
+	}
+	if err = probe.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+	if err = probe.SetPeerSalt(hello.Salt); err != nil {
+		t.Fatal(err)
+	}
+	reply, err := probe.SignHello(Hello{Reply: true, Versions: SupportedVersions})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = host.VerifyHello(reply); err != nil {
+		t.Fatalf("verify reply: %v", err)
+	}
+	if err = host.Rekey(); err != nil {
+		t.Fatal(err)
+	}
+	if err = host.VerifyHello(reply); !errors.Is(err, ErrAuthFailed) {
+		t.Errorf("reply replayed after rekey: got %v", err)
+	}
+
+	// 已使用过的发起方随机数视为重放
+	if err = probe.VerifyHello(hello); !errors.Is(err, ErrReplayed) {
+		t.Errorf("replayed hello: got %v", err)
+	}
+}
@@ -0,0 +1,150 @@
+package serial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"go.uber.org/zap"
+)
+
+// Framing 连接使用的帧格式
+type Framing int
+
+const (
+	FramingUnknown Framing = iota // 尚未收到数据
+	FramingLegacy                 // 旧版 316 字节定长帧（.fa、.fa2）
+	FramingCAFE                   // 0xCAFE 协议帧（.fa00）
+)
+
+// String 帧格式名称
+func (f Framing) String() string {
+	switch f {
+	case FramingUnknown:
+		return "unknown"
+	case FramingLegacy:
+		return "legacy"
+	case FramingCAFE:
+		return "0xCAFE"
+	default:
+		return fmt.Sprintf("Framing(%d)", int(f))
+	}
+}
+
+// magicPrefix 0xCAFE 协议帧的起始字节
+var magicPrefix = []byte{byte(MagicNumber >> 8), byte(MagicNumber & 0xFF)}
+
+// ServeAuto 监听帧格式未知的连接，直到连接断开或 ctx 取消，返回 *ListenError 说明停止原因。
+//
+// 根据连接的起始字节选择解码方式：以 Magic Number 开头时按 0xCAFE 协议（物理串口通道）处理，
+// 否则按旧版定长帧处理，legacy 指定旧版数据所属的通道（ChannelTask 或 ChannelCollect）。
+// 旧版模式下在每个帧边界检查 Magic Number，探针升级后切换到 0xCAFE 协议；切换不可逆。
+// 切换后连接属于 ChannelSerial：注册表改为登记在物理串口通道下，断开事件与 *ListenError 的通道同样为 ChannelSerial。
+// 检测到的帧格式通过 SessionConfig.OnFraming 上报，也可通过 Framing 查询
+func (s *Session) ServeAuto(ctx context.Context, conn io.ReadWriteCloser, legacy Channel) error {
+	if legacy != ChannelTask && legacy != ChannelCollect {
+		return fmt.Errorf("旧版数据通道必须为 %s 或 %s: %s", ChannelTask, ChannelCollect, legacy)
+	}
+	s.resetSequence()
+	served := &servedConn{conn: conn, channel: legacy}
+	r := &autoReader{s: s, conn: served, legacy: s.newLegacyReader(legacy), serial: s.newSerialReader()}
+	s.setFraming(FramingUnknown)
+
+	err := s.serve(ctx, served, r.process)
+	// serve 只清理连接当前所属的通道，切换前旧版通道的未完成数据同样需要上报
+	var listenErr *ListenError
+	if errors.As(err, &listenErr) && served.channel != legacy {
+		listenErr.Pending += s.flush(legacy, listenErr.Err)
+	}
+	return err
+}
+
+// Framing 最近一次 ServeAuto 检测到的帧格式
+func (s *Session) Framing() Framing {
+	s.framingMutex.Lock()
+	defer s.framingMutex.Unlock()
+	return s.framing
+}
+
+// setFraming 记录检测到的帧格式，发生变化时上报
+func (s *Session) setFraming(framing Framing) {
+	s.framingMutex.Lock()
+	changed := s.framing != framing
+	s.framing = framing
+	s.framingMutex.Unlock()
+	if !changed || framing == FramingUnknown {
+		return
+	}
+	s.log.Info("检测到帧格式", zap.Stringer("framing", framing))
+	if s.registry != nil {
+		s.registry.SetFraming(s.kvmID, framing)
+	}
+	if s.onFraming != nil {
+		s.onFraming(framing)
+	}
+}
+
+// autoReader 自动检测帧格式的流式读取状态
+type autoReader struct {
+	s       *Session
+	conn    *servedConn
+	legacy  *legacyReader
+	serial  *serialReader
+	framing Framing
+	pending []byte // 帧边界处不足以判断帧格式的数据
+}
+
+// process 处理从连接读取的一块数据
+func (r *autoReader) process(buf []byte) {
+	if r.framing == FramingCAFE {
+		r.serial.process(buf)
+		return
+	}
+
+	data := append(r.pending, buf...)
+	r.pending = nil
+	for len(data) > 0 {
+		if r.legacy.buffered() > 0 {
+			// 帧中间的数据交给旧版解码，补齐当前定长帧后回到帧边界
+			n := BufSize - r.legacy.buffered()
+			if n > len(data) {
+				n = len(data)
+			}
+			r.legacy.process(data[:n])
+			data = data[n:]
+			continue
+		}
+
+		// 帧边界：判断是否为 0xCAFE 协议帧
+		if len(data) < len(magicPrefix) && bytes.HasPrefix(magicPrefix, data) {
+			r.pending = append([]byte(nil), data...)
+			return
+		}
+		if bytes.HasPrefix(data, magicPrefix) {
+			r.switchTo(FramingCAFE)
+			r.serial.process(data)
+			return
+		}
+		r.switchTo(FramingLegacy)
+		n := BufSize
+		if n > len(data) {
+			n = len(data)
+		}
+		r.legacy.process(data[:n])
+		data = data[n:]
+	}
+}
+
+// switchTo 切换帧格式
+func (r *autoReader) switchTo(framing Framing) {
+	if r.framing == framing {
+		return
+	}
+	r.framing = framing
+	if framing == FramingCAFE {
+		r.s.switchChannel(r.conn, ChannelSerial)
+	}
+	r.s.setFraming(framing)
+}
@@ -0,0 +1,64 @@
+package serial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServeAutoSwitchChannel(t *testing.T) {
+	registry := NewAgentRegistry()
+	var messages []Message
+	var disconnects []Event
+	s := NewSession("auto", SessionConfig{
+		Registry: registry,
+		Handler: func(msg Message) error {
+			messages = append(messages, msg)
+			return nil
+		},
+		OnEvent: func(event Event) {
+			if event.Kind == EventDisconnect {
+				disconnects = append(disconnects, event)
+			}
+		},
+	})
+	server, client := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- s.ServeAuto(context.Background(), server, ChannelCollect) }()
+
+	data := payload(10)
+	if _, err := client.Write(bytes.Join(writeFrames(t, 1, data), nil)); err != nil {
+		t.Fatal(err)
+	}
+	// 切换到 0xCAFE 协议后连接登记在物理串口通道下
+	deadline := time.Now().Add(time.Second)
+	for {
+		agent, _ := registry.Lookup("auto")
+		if _, ok := agent.Conns[ChannelSerial]; ok || time.Now().After(deadline) {
+			if _, legacy := agent.Conns[ChannelCollect]; !ok || legacy {
+				t.Fatalf("got channels %v, want only %s", agent.Channels(), ChannelSerial)
+			}
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	_ = client.Close()
+	err := <-done
+	var listenErr *ListenError
+	if !errors.As(err, &listenErr) || listenErr.Channel != ChannelSerial {
+		t.Errorf("got %v, want ListenError on %s", err, ChannelSerial)
+	}
+	if len(disconnects) != 1 || disconnects[0].Channel != ChannelSerial {
+		t.Errorf("got disconnect events %v, want one on %s", disconnects, ChannelSerial)
+	}
+	if _, ok := registry.Lookup("auto"); ok {
+		t.Error("agent still registered after disconnect")
+	}
+	if len(messages) != 1 || messages[0].Channel != ChannelSerial || !bytes.Equal(messages[0].Data, data) {
+		t.Errorf("got %d messages, want 1 on %s", len(messages), ChannelSerial)
+	}
+}
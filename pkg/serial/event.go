@@ -0,0 +1,126 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+// EventKind 监听事件类型
+type EventKind int
+
+const (
+	EventCRCMismatch     EventKind = iota + 1 // 数据包CRC校验失败
+	EventVersionMismatch                      // 数据包协议版本不支持
+	EventMalformed                            // 数据包格式错误或不完整
+	EventSequenceGap                          // 数据包序列号不连续，未完成的数据被丢弃
+	EventOversize                             // 重组数据超过单条最大长度或会话内存预算，未完成的数据被淘汰
+	EventHandlerError                         // 完整数据处理函数返回错误
+	EventDisconnect                           // 连接断开或 ctx 取消，监听停止
+	EventAuthFailed                           // 安全事件：配置加密时收到未加密或认证失败（伪造、篡改）的数据包
+	EventReplayed                             // 收到重复或超出重放窗口的数据包，配置加密时为安全事件，见 SequencePolicy
+)
+
+// Security 是否为安全事件，未配置加密时的 EventReplayed 同样视为安全事件
+func (k EventKind) Security() bool {
+	return k == EventAuthFailed || k == EventReplayed
+}
+
+// String 事件类型名称
+func (k EventKind) String() string {
+	switch k {
+	case EventCRCMismatch:
+		return "CRCMismatch"
+	case EventVersionMismatch:
+		return "VersionMismatch"
+	case EventMalformed:
+		return "Malformed"
+	case EventSequenceGap:
+		return "SequenceGap"
+	case EventOversize:
+		return "Oversize"
+	case EventHandlerError:
+		return "HandlerError"
+	case EventDisconnect:
+		return "Disconnect"
+	case EventAuthFailed:
+		return "AuthFailed"
+	case EventReplayed:
+		return "Replayed"
+	default:
+		return fmt.Sprintf("EventKind(%d)", int(k))
+	}
+}
+
+// Event 监听过程中发生的异常事件，TaskID 与 SeqNum 为出错数据包的帧信息，无法确定时为零值
+type Event struct {
+	Kind    EventKind // 事件类型
+	KvmID   string    // 虚拟机ID
+	Channel Channel   // 接收通道
+	TaskID  int       // 任务ID
+	SeqNum  uint32    // 序列号
+	Err     error     // 具体错误
+}
+
+func (e Event) Error() string {
+	return fmt.Sprintf("agent[%s]%s %s（任务ID: %d，序列号: %d）: %v", e.KvmID, e.Channel, e.Kind, e.TaskID, e.SeqNum, e.Err)
+}
+
+func (e Event) Unwrap() error {
+	return e.Err
+}
+
+// frameEvent 将物理串口通道的解码错误转换为事件
+func frameEvent(err error) Event {
+	event := Event{Kind: EventMalformed, Channel: ChannelSerial, Err: err}
+	var frameErr *protocol.FrameError
+	if errors.As(err, &frameErr) {
+		event.TaskID = int(frameErr.Header.TaskID)
+		event.SeqNum = frameErr.Header.SeqNum
+	}
+	switch {
+	case errors.Is(err, protocol.ErrBadCRC):
+		event.Kind = EventCRCMismatch
+	case errors.Is(err, protocol.ErrBadVersion):
+		event.Kind = EventVersionMismatch
+	}
+	return event
+}
+
+// emit 上报监听事件，未设置 OnEvent 时记录日志
+func (s *Session) emit(event Event) {
+	event.KvmID = s.kvmID
+	if s.onEvent != nil {
+		s.onEvent(event)
+		return
+	}
+	fields := []zap.Field{
+		zap.Stringer("event", event.Kind),
+		zap.Stringer("channel", event.Channel),
+		zap.Int("taskID", event.TaskID),
+		zap.Uint32("seqNum", event.SeqNum),
+		zap.Error(event.Err),
+	}
+	switch event.Kind {
+	case EventHandlerError:
+		s.log.Error("处理数据失败:", fields...)
+	case EventDisconnect:
+		s.log.Info("连接断开", fields...)
+	case EventAuthFailed, EventReplayed:
+		s.log.Warn("拒绝不可信数据包", fields...)
+	default:
+		// 被丢弃的未完成数据另由 discard 上报
+		s.log.Debug("丢弃无效数据包", fields...)
+	}
+}
+
+// securityEvent 将加密认证错误转换为安全事件
+func securityEvent(header protocol.PacketHeader, err error) Event {
+	kind := EventAuthFailed
+	if errors.Is(err, protocol.ErrReplayed) || errors.Is(err, protocol.ErrOutOfWindow) {
+		kind = EventReplayed
+	}
+	return Event{Kind: kind, Channel: ChannelSerial, TaskID: int(header.TaskID), SeqNum: header.SeqNum, Err: err}
+}
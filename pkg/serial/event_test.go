@@ -0,0 +1,94 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+// recordEvents 创建记录事件的会话，handler 为 nil 时数据处理成功
+func recordEvents(handler ProcessMessageFunc) (*Session, *[]Event) {
+	var events []Event
+	if handler == nil {
+		handler = func(Message) error { return nil }
+	}
+	s := NewSession("vm-1", SessionConfig{
+		Handler:   handler,
+		OnDiscard: func(PartialMessage) {},
+		OnEvent:   func(event Event) { events = append(events, event) },
+	})
+	return s, &events
+}
+
+func TestSessionFrameEvents(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(frame []byte)
+		kind    EventKind
+	}{
+		{name: "crc", corrupt: func(frame []byte) { frame[len(frame)-1] ^= 0xFF }, kind: EventCRCMismatch},
+		{name: "version", corrupt: func(frame []byte) { frame[2] = 9 }, kind: EventVersionMismatch},
+	}
+	for _, tt := range tests {
+		frames := writeFrames(t, 4, payload(100))
+		tt.corrupt(frames[0])
+
+		s, events := recordEvents(nil)
+		s.newSerialReader().process(frames[0])
+		if len(*events) != 1 {
+			t.Fatalf("%s: got %d events, want 1", tt.name, len(*events))
+		}
+		event := (*events)[0]
+		if event.Kind != tt.kind || event.KvmID != "vm-1" || event.Channel != ChannelSerial {
+			t.Errorf("%s: got event %+v", tt.name, event)
+		}
+	}
+}
+
+func TestSessionSequenceGapEvent(t *testing.T) {
+	frames := writeFrames(t, 4, payload(900))
+	s, events := recordEvents(nil)
+	r := s.newSerialReader()
+	r.process(frames[0])
+	r.process(frames[2])
+
+	if len(*events) != 1 {
+		t.Fatalf("got %d events, want 1", len(*events))
+	}
+	event := (*events)[0]
+	if event.Kind != EventSequenceGap || event.TaskID != 4 || event.SeqNum != 2 || !errors.Is(event, ErrSequenceGap) {
+		t.Errorf("got event %+v, want SequenceGap at task 4 seq 2", event)
+	}
+}
+
+func TestSessionHandlerErrorEvent(t *testing.T) {
+	failure := errors.New("handler failed")
+	s, events := recordEvents(func(Message) error { return failure })
+	s.newSerialReader().process(writeFrames(t, 6, payload(10))[0])
+
+	if len(*events) != 1 {
+		t.Fatalf("got %d events, want 1", len(*events))
+	}
+	if event := (*events)[0]; event.Kind != EventHandlerError || event.TaskID != 6 || !errors.Is(event, failure) {
+		t.Errorf("got event %+v, want HandlerError for task 6", event)
+	}
+	if n := s.Stats().HandlerErrors; n != 1 {
+		t.Errorf("got %d handler errors, want 1", n)
+	}
+}
+
+func TestSessionDisconnectEvent(t *testing.T) {
+	s, events := recordEvents(nil)
+	conn, peer := net.Pipe()
+	_ = peer.Close()
+
+	err := s.ServeSerial(context.Background(), conn)
+	var listenErr *ListenError
+	if !errors.As(err, &listenErr) || listenErr.Channel != ChannelSerial {
+		t.Fatalf("got %v, want ListenError", err)
+	}
+	if len(*events) != 1 || (*events)[0].Kind != EventDisconnect {
+		t.Errorf("got events %+v, want one Disconnect", *events)
+	}
+}
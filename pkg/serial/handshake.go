@@ -0,0 +1,129 @@
+package serial
+
+import (
+	"errors"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+// ErrNoCommonVersion 握手时双方没有共同支持的协议版本
+var ErrNoCommonVersion = errors.New("与对端没有共同支持的协议版本")
+
+// Negotiated 握手协商结果
+type Negotiated struct {
+	Version      byte   // 双方共同支持的最高协议版本
+	Capabilities uint32 // 双方共同支持的能力位
+	Peer         protocol.Hello
+}
+
+// Handshake 向对端发送握手数据包，收到对端应答后写入器切换到协商的协议版本；
+// 旧版探针不应答握手，此时继续使用 v1 协议。需设置 SessionConfig.Writer。
+// 配置加密时先生成新的本端随机数，收到对端应答并交换随机数后才能收发加密帧，每次建立连接后都须握手
+func (s *Session) Handshake() error {
+	if s.writer == nil {
+		return errors.New("会话未配置写入器，无法握手")
+	}
+	if s.sealer != nil {
+		if err := s.sealer.Rekey(); err != nil {
+			return err
+		}
+	}
+	return s.writer.WriteHello(false)
+}
+
+// Negotiated 握手协商结果，尚未完成握手时 ok 为 false
+func (s *Session) Negotiated() (negotiated Negotiated, ok bool) {
+	s.handshakeMutex.Lock()
+	defer s.handshakeMutex.Unlock()
+	if s.negotiated == nil {
+		return Negotiated{}, false
+	}
+	return *s.negotiated, true
+}
+
+// handleHello 处理对端的握手数据包：协商协议版本，未应答过的 Hello 回复本端的 Hello
+func (s *Session) handleHello(packet *protocol.DataPacket) {
+	peer, err := protocol.DecodeHello(packet.Data)
+	if err != nil {
+		s.emit(Event{Kind: EventMalformed, Channel: ChannelSerial, SeqNum: packet.Header.SeqNum, Err: err})
+		return
+	}
+
+	// 配置加密时先校验认证码，伪造或重放的 Hello 不能触发重新握手
+	if s.sealer != nil {
+		if err = s.sealer.VerifyHello(peer); err != nil {
+			s.emit(securityEvent(packet.Header, err))
+			return
+		}
+	}
+
+	local := protocol.Hello{Versions: protocol.SupportedVersions}
+	if s.writer != nil {
+		local = s.writer.Hello()
+	}
+	version, capabilities, ok := protocol.Negotiate(local, peer)
+	if !ok {
+		s.emit(Event{Kind: EventVersionMismatch, Channel: ChannelSerial, SeqNum: packet.Header.SeqNum, Err: ErrNoCommonVersion})
+		return
+	}
+	if s.sealer != nil {
+		if err = s.exchangeSalt(peer); err != nil {
+			s.emit(securityEvent(packet.Header, err))
+			return
+		}
+	}
+
+	s.handshakeMutex.Lock()
+	s.negotiated = &Negotiated{Version: version, Capabilities: capabilities, Peer: peer}
+	s.handshakeMutex.Unlock()
+	// 对端重新握手后序列号重新开始，之前交付数据的范围不再用于识别重发
+	s.mu.Lock()
+	s.delivered = make(map[bufferKey]seqRange)
+	s.mu.Unlock()
+	s.log.Info("协议握手完成", zap.Uint8("version", version), zap.Uint32("capabilities", capabilities))
+	if s.registry != nil {
+		s.registry.SetVersion(s.kvmID, version)
+	}
+
+	if s.writer != nil {
+		go s.replyHello(version, capabilities, !peer.Reply)
+	}
+}
+
+// exchangeSalt 配置加密时使用对端 Hello（已校验认证码）中的随机数派生帧密钥并重置序列号窗口：
+// 对端发起的握手先生成新的本端随机数，应答中携带，重放之前的 Hello 无法恢复之前连接的帧密钥
+func (s *Session) exchangeSalt(peer protocol.Hello) error {
+	if !peer.Reply {
+		if err := s.sealer.Rekey(); err != nil {
+			return err
+		}
+	}
+	if err := s.sealer.SetPeerSalt(peer.Salt); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.replay.Reset()
+	s.mu.Unlock()
+	return nil
+}
+
+// replyHello 按需回复握手应答，应答发出后再切换协议版本，保证对端先收到 v1 的握手应答
+func (s *Session) replyHello(version byte, capabilities uint32, reply bool) {
+	if reply {
+		if err := s.writer.WriteHello(true); err != nil {
+			s.log.Warn("发送握手应答失败", zap.Error(err))
+		}
+	}
+	_ = s.writer.SetNegotiated(version, capabilities)
+}
+
+// resetHandshake 清除握手协商结果，物理串口通道重连后对端可能已更换
+func (s *Session) resetHandshake() {
+	s.handshakeMutex.Lock()
+	s.negotiated = nil
+	s.handshakeMutex.Unlock()
+	if s.registry != nil {
+		s.registry.SetVersion(s.kvmID, 0)
+	}
+}
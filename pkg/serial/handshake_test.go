@@ -0,0 +1,247 @@
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+)
+
+// sealedPeer 配置加密的一端：写入器经 link 发往对端，会话处理对端发来的帧
+type sealedPeer struct {
+	writer  *Writer
+	session *Session
+	reader  *serialReader
+
+	mu       sync.Mutex
+	messages [][]byte
+	events   []Event
+}
+
+// captureLink 将写入的帧交给对端处理，并记录每一帧
+type captureLink struct {
+	mu       sync.Mutex
+	frames   [][]byte
+	peer     *sealedPeer
+	dropAcks int // 丢弃的 Ack 个数
+}
+
+func (l *captureLink) Write(p []byte) (int, error) {
+	frame := append([]byte(nil), p...)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.frames = append(l.frames, frame)
+	// 头部不加密，第 8 字节为数据包类型
+	if l.dropAcks > 0 && frame[7] == protocol.PacketTypeAck {
+		l.dropAcks--
+		return len(p), nil
+	}
+	l.peer.reader.process(frame)
+	return len(p), nil
+}
+
+func newSealedPeer(t *testing.T, key []byte, role protocol.Role, link *captureLink, config WriterConfig) *sealedPeer {
+	t.Helper()
+	sealer, err := protocol.NewSealer(key, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &sealedPeer{}
+	config.Pacer = NoPacer
+	config.Sealer = sealer
+	if p.writer, err = NewWriter(link, nil, config); err != nil {
+		t.Fatal(err)
+	}
+	p.session = NewSession("sealed", SessionConfig{
+		Writer:   p.writer,
+		Sealer:   sealer,
+		Reliable: config.RetransmitWindow > 0,
+		Handler: func(msg Message) error {
+			p.mu.Lock()
+			p.messages = append(p.messages, msg.Data)
+			p.mu.Unlock()
+			return nil
+		},
+		OnEvent: func(event Event) {
+			p.mu.Lock()
+			p.events = append(p.events, event)
+			p.mu.Unlock()
+		},
+	})
+	p.reader = p.session.newSerialReader()
+	return p
+}
+
+// connect 创建一对使用同一预共享密钥的主机与探针并完成握手
+func connect(t *testing.T, key []byte) (host, probe *sealedPeer, toProbe *captureLink) {
+	t.Helper()
+	host, probe, toProbe, _ = connectWith(t, key, WriterConfig{})
+	return host, probe, toProbe
+}
+
+// connectWith 按写入配置创建一对主机与探针并完成握手，配置重传窗口时启用可靠传输
+func connectWith(t *testing.T, key []byte, config WriterConfig) (host, probe *sealedPeer, toProbe, toHost *captureLink) {
+	t.Helper()
+	toProbe, toHost = &captureLink{}, &captureLink{}
+	host = newSealedPeer(t, key, protocol.RoleHost, toProbe, config)
+	probe = newSealedPeer(t, key, protocol.RoleProbe, toHost, config)
+	toProbe.peer, toHost.peer = probe, host
+	if err := host.session.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	// 应答在独立的 goroutine 中发送
+	deadline := time.Now().Add(time.Second)
+	for {
+		_, hostOK := host.session.Negotiated()
+		_, probeOK := probe.session.Negotiated()
+		if hostOK && probeOK || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	return host, probe, toProbe, toHost
+}
+
+func (p *sealedPeer) received() ([][]byte, []Event) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([][]byte(nil), p.messages...), append([]Event(nil), p.events...)
+}
+
+func TestSealedHandshakeReplay(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	data := payload(500)
+
+	host, probe, toProbe := connect(t, key)
+	if err := host.writer.WriteTask(1, 9, data); err != nil {
+		t.Fatal(err)
+	}
+	if messages, _ := probe.received(); len(messages) != 1 || !bytes.Equal(messages[0], data) {
+		t.Fatalf("first run: probe got %d messages, want 1", len(messages))
+	}
+	toProbe.mu.Lock()
+	captured := bytes.Join(toProbe.frames, nil)
+	toProbe.mu.Unlock()
+
+	// 同一密钥的新连接：序列号重新开始，帧密钥不同，之前截获的帧（含 Hello）均不能交付
+	host2, probe2, toProbe2 := connect(t, key)
+	probe2.reader.process(captured)
+	if messages, _ := probe2.received(); len(messages) != 0 {
+		t.Fatalf("replayed frames delivered %d messages", len(messages))
+	}
+	if _, events := probe2.received(); len(events) == 0 || !errors.Is(events[len(events)-1].Err, protocol.ErrAuthFailed) {
+		t.Errorf("replayed frames not rejected as unauthenticated: %v", events)
+	}
+
+	// 相同序列号在两次连接中的密文不同
+	if err := host2.writer.WriteTask(1, 9, data); err != nil {
+		t.Fatal(err)
+	}
+	toProbe2.mu.Lock()
+	second := toProbe2.frames[len(toProbe2.frames)-1]
+	toProbe2.mu.Unlock()
+	if bytes.Contains(captured, second[protocol.HeaderSizeV2:len(second)-protocol.CRCSize]) {
+		t.Error("ciphertext repeated across connections")
+	}
+}
+
+// helloFrame 编码未加密的 Hello 数据包
+func helloFrame(t *testing.T, hello protocol.Hello) []byte {
+	t.Helper()
+	frame, err := protocol.DataPacket{
+		Header: PacketHeader{MagicNumber: MagicNumber, Version: ProtocolVersion, PacketType: protocol.PacketTypeHello, Status: byte(protocol.DataEnd)},
+		Data:   protocol.EncodeHello(hello),
+	}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func TestSealedForgedHello(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	host, probe, toProbe := connect(t, key)
+	toProbe.mu.Lock()
+	captured := toProbe.frames[0]
+	toProbe.mu.Unlock()
+
+	forger, err := protocol.NewSealer(bytes.Repeat([]byte{0x24}, 32), protocol.RoleHost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := forger.SignHello(host.writer.Hello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := host.writer.Hello()
+	unsigned.Salt = forger.Salt()
+
+	tests := []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{name: "wrong key", frame: helloFrame(t, forged), err: protocol.ErrAuthFailed},
+		{name: "unsigned", frame: helloFrame(t, unsigned), err: protocol.ErrAuthFailed},
+		{name: "replayed", frame: captured, err: protocol.ErrReplayed},
+	}
+	for i, tt := range tests {
+		probe.reader.process(tt.frame)
+		_, events := probe.received()
+		if len(events) != i+1 || !errors.Is(events[i].Err, tt.err) || !events[i].Kind.Security() {
+			t.Fatalf("%s: got events %v, want %v", tt.name, events, tt.err)
+		}
+
+		// 被拒绝的 Hello 不改变帧密钥，之后的数据照常交付
+		data := payload(100 + i)
+		if err = host.writer.WriteTask(1, 9, data); err != nil {
+			t.Fatal(err)
+		}
+		if messages, _ := probe.received(); len(messages) != i+1 || !bytes.Equal(messages[i], data) {
+			t.Fatalf("%s: probe got %d messages, want %d", tt.name, len(messages), i+1)
+		}
+	}
+}
+
+func TestSealedReliableLostAck(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+	host, probe, _, toHost := connectWith(t, key, WriterConfig{RetransmitWindow: 16, AckTimeout: 50 * time.Millisecond})
+	toHost.mu.Lock()
+	toHost.dropAcks = 1
+	toHost.mu.Unlock()
+
+	data := payload(3000)
+	if err := host.writer.WriteTask(1, 9, data); err != nil {
+		t.Fatal(err)
+	}
+	// Ack 丢失后主机超时重发，探针识别已交付数据的重发帧并重新 Ack，不当作重放
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		host.writer.windowMu.Lock()
+		pending := len(host.writer.window) + len(host.writer.awaiting)
+		host.writer.windowMu.Unlock()
+		if pending == 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if pending := len(host.writer.window); pending != 0 {
+		t.Fatalf("%d tasks still in retransmit window", pending)
+	}
+	messages, events := probe.received()
+	if len(messages) != 1 || !bytes.Equal(messages[0], data) {
+		t.Fatalf("probe got %d messages, want 1", len(messages))
+	}
+	for _, event := range events {
+		if event.Kind.Security() {
+			t.Errorf("unexpected security event: %v", event)
+		}
+	}
+	if n := probe.session.Stats().PacketsReplayed; n != 0 {
+		t.Errorf("got %d replayed packets, want 0", n)
+	}
+}
@@ -1,394 +1,558 @@
 package serial
 
 import (
-	"encoding/binary"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/xuchao-ovo/agent-sdk-go/global"
 	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
 	"go.uber.org/zap"
-	"hash/crc32"
+	"io"
 	"net"
-	"sync"
+	"sync/atomic"
+	"time"
 )
 
-const BufSize = 316
-
-// 用于缓存每个业务的数据包
-var taskDataMap = make(map[int][]byte)
-var taskDataMapMutex sync.Mutex
-
-// 用于缓存每个采集任务的数据包
-var collectTaskDataMap = make(map[int][]byte)
-var collectTaskDataMapMutex sync.Mutex
-
-// 用于缓存物理串口的的数据包
-var packetBuffers = make(map[byte]*protocol.PacketBuffer)
-var packetBuffersMutex sync.Mutex
-
-// 定义日志
-var gvaLog *zap.Logger
+// BufSize 旧版通道定长帧长度，也是每次读取连接的缓冲区大小
+const BufSize = protocol.LegacyFrameSize
 
+// 0xCAFE 协议常量，定义见 protocol 包
 const (
-	MagicNumber     uint16 = 0xCAFE
-	ProtocolVersion byte   = 0x01
-	HeaderSize             = 12
-	CRCSize                = 4
-	MinPacketSize          = HeaderSize + CRCSize
+	MagicNumber     = protocol.MagicNumber
+	ProtocolVersion = protocol.ProtocolVersion
+	HeaderSize      = protocol.HeaderSize
+	CRCSize         = protocol.CRCSize
+	MinPacketSize   = protocol.MinPacketSize
+)
+
+// 未完成数据的丢弃原因
+var (
+	ErrSequenceGap      = errors.New("数据包序列号不连续")
+	ErrMessageRestarted = errors.New("同一任务ID重新开始传输")
+	ErrLengthMismatch   = errors.New("重组数据长度与帧头部不一致")
 )
 
 // ProcessCompleteDataFunc 定义外部传入的 ProcessCompleteDataFunc 函数签名
 type ProcessCompleteDataFunc func(packetType int, data []byte, kvmID string) error
 
-// ListenConnection 监听数据采集连接通道数据（.fa2）
-func ListenConnection(conn net.Conn, kvmID string, agentMap map[string]interface{}, agentMapMutex sync.Mutex, log *zap.Logger, processCompleteTaskData ProcessCompleteDataFunc) {
-	defer conn.Close()
-	gvaLog = log
+// Message 重组完成的完整数据，携带最后一个数据包的帧信息
+type Message struct {
+	KvmID      string  // 虚拟机ID
+	Channel    Channel // 接收通道
+	PacketType int     // 数据类型
+	TaskID     int     // 任务ID，应答数据与请求使用相同的任务ID
+	SeqNum     uint32  // 最后一个数据包的序列号
+	Data       []byte  // 完整数据
+}
 
-	var receivedBuf []byte
-	for {
-		buf := make([]byte, BufSize)
-		n, err := conn.Read(buf)
-		if err != nil {
-			gvaLog.Error("Error reading from socket:", zap.Error(err))
-			gvaLog.Info(fmt.Sprintf("agent[%s].fa2 连接断开", kvmID))
-			agentMapMutex.Lock()
-			delete(agentMap, kvmID)
-			agentMapMutex.Unlock()
-			return
-		}
+// ProcessMessageFunc 完整数据处理函数，相比 ProcessCompleteDataFunc 额外携带帧信息
+type ProcessMessageFunc func(msg Message) error
 
-		// 过滤无效数据包
-		if int(buf[0]) != global.TaskCollect && int(buf[0]) != global.MetricCollect {
-			continue
-		}
+// ListenConnection 监听数据采集连接通道数据（.fa2），监听期间在 registry 中登记该探针，连接断开后注销；
+// registry 为 nil 时不登记
+func ListenConnection(conn net.Conn, kvmID string, registry *AgentRegistry, log *zap.Logger, processCompleteTaskData ProcessCompleteDataFunc) {
+	NewSession(kvmID, SessionConfig{Logger: log, Handler: completeDataHandler(processCompleteTaskData), Registry: registry}).ListenCollect(conn)
+}
 
-		receivedBuf = append(receivedBuf, buf[:n]...)
-		// 兼容旧版本心跳采集数据
-		for len(receivedBuf) >= 3 && len(receivedBuf) < BufSize {
-			dataStatus := int(receivedBuf[2])
-			taskID := int(receivedBuf[1])
-			// 过滤无效数据包
-			if isValidPacket(taskID, dataStatus) {
-				continue
-			}
-			packetLen := int(receivedBuf[1])<<8 | int(receivedBuf[2])
-			totalLen := 3 + packetLen
+// ListenTaskConnection 监听任务连接通道数据（.fa）
+func ListenTaskConnection(conn net.Conn, kvmID string, log *zap.Logger, processCompleteTaskData ProcessCompleteDataFunc) {
+	NewSession(kvmID, SessionConfig{Logger: log, Handler: completeDataHandler(processCompleteTaskData)}).ListenTask(conn)
+}
 
-			if len(receivedBuf) < totalLen {
-				break // 数据不完整，继续等待接收
-			}
-			packetType := int(receivedBuf[0])
-			data := receivedBuf[3:totalLen]
-			// 移除已处理的数据
-			receivedBuf = receivedBuf[totalLen:]
-			// 解析完整数据
-			err = processCompleteTaskData(packetType, data, kvmID)
-			if err != nil {
-				gvaLog.Error("解析数据错误:", zap.Error(err))
-				continue
-			}
-		}
+// ListenSerialConnection 监听物理串口连接通道数据（.fa00）
+func ListenSerialConnection(conn net.Conn, kvmID string, log *zap.Logger, processCompleteTaskData ProcessCompleteDataFunc) {
+	ListenSerialMessages(conn, kvmID, log, completeDataHandler(processCompleteTaskData))
+}
 
-		// 处理接收的数据包
-		for len(receivedBuf) >= BufSize {
-			// 解析数据包头部信息
-			packet := receivedBuf[:BufSize]
-			receivedBuf = receivedBuf[BufSize:]
-			dataLen := int(packet[3])<<16 | int(packet[4])<<8 | int(packet[5])
-			totalLen := 6 + dataLen
-			dataStatus := int(packet[2])
-			taskID := int(packet[1])
-			// 过滤无效数据包
-			if !isValidPacket(taskID, dataStatus) {
-				continue
-			}
-			// 开始接收数据，添加数据到缓存中
-			if dataStatus == protocol.DataStart {
-				collectTaskDataMapMutex.Lock()
-				if _, ok := collectTaskDataMap[taskID]; ok {
-					delete(collectTaskDataMap, taskID)
-				}
-				collectTaskDataMap[taskID] = append(collectTaskDataMap[taskID], packet...)
-				collectTaskDataMapMutex.Unlock()
-			}
-			// 跳过不完整数据包
-			collectTaskDataMapMutex.Lock()
-			if _, ok := collectTaskDataMap[taskID]; !ok && totalLen >= BufSize {
-				collectTaskDataMapMutex.Unlock()
-				continue
-			}
-			collectTaskDataMapMutex.Unlock()
-			// 处理传输中数据，追加数据到缓存中
-			if dataStatus == protocol.DataTransfer {
-				collectTaskDataMapMutex.Lock()
-				collectTaskDataMap[taskID] = append(collectTaskDataMap[taskID], packet[6:]...)
-				collectTaskDataMapMutex.Unlock()
+// ListenSerialMessages 监听物理串口连接通道数据（.fa00），回调中携带任务ID与序列号
+func ListenSerialMessages(conn net.Conn, kvmID string, log *zap.Logger, processMessage ProcessMessageFunc) {
+	NewSession(kvmID, SessionConfig{Logger: log, Handler: processMessage}).ListenSerial(conn)
+}
+
+// ListenConnectionContext 监听数据采集连接通道数据（.fa2），直到连接断开或 ctx 取消，返回 *ListenError 说明停止原因
+func ListenConnectionContext(ctx context.Context, conn net.Conn, kvmID string, log *zap.Logger, processCompleteTaskData ProcessCompleteDataFunc) error {
+	return NewSession(kvmID, SessionConfig{Logger: log, Handler: completeDataHandler(processCompleteTaskData)}).ServeCollect(ctx, conn)
+}
+
+// ListenTaskConnectionContext 监听任务连接通道数据（.fa），直到连接断开或 ctx 取消，返回 *ListenError 说明停止原因
+func ListenTaskConnectionContext(ctx context.Context, conn net.Conn, kvmID string, log *zap.Logger, processCompleteTaskData ProcessCompleteDataFunc) error {
+	return NewSession(kvmID, SessionConfig{Logger: log, Handler: completeDataHandler(processCompleteTaskData)}).ServeTask(ctx, conn)
+}
+
+// ListenSerialConnectionContext 监听物理串口连接通道数据（.fa00），直到连接断开或 ctx 取消，返回 *ListenError 说明停止原因
+func ListenSerialConnectionContext(ctx context.Context, conn net.Conn, kvmID string, log *zap.Logger, processCompleteTaskData ProcessCompleteDataFunc) error {
+	return NewSession(kvmID, SessionConfig{Logger: log, Handler: completeDataHandler(processCompleteTaskData)}).ServeSerial(ctx, conn)
+}
+
+// ListenAutoConnectionContext 监听帧格式未知的连接，自动识别旧版定长帧与 0xCAFE 协议帧，
+// legacy 为旧版数据所属的通道，直到连接断开或 ctx 取消，返回 *ListenError 说明停止原因
+func ListenAutoConnectionContext(ctx context.Context, conn net.Conn, kvmID string, legacy Channel, log *zap.Logger, processMessage ProcessMessageFunc) error {
+	return NewSession(kvmID, SessionConfig{Logger: log, Handler: processMessage}).ServeAuto(ctx, conn, legacy)
+}
+
+// completeDataHandler 将 ProcessCompleteDataFunc 适配为 ProcessMessageFunc
+func completeDataHandler(processCompleteTaskData ProcessCompleteDataFunc) ProcessMessageFunc {
+	return func(msg Message) error {
+		return processCompleteTaskData(msg.PacketType, msg.Data, msg.KvmID)
+	}
+}
+
+// ListenCollect 监听数据采集连接通道数据（.fa2）
+func (s *Session) ListenCollect(conn io.ReadWriteCloser) {
+	_ = s.ServeCollect(context.Background(), conn)
+}
+
+// ServeCollect 监听数据采集连接通道数据（.fa2），直到连接断开或 ctx 取消，返回 *ListenError 说明停止原因
+func (s *Session) ServeCollect(ctx context.Context, conn io.ReadWriteCloser) error {
+	return s.serve(ctx, &servedConn{conn: conn, channel: ChannelCollect}, s.newLegacyReader(ChannelCollect).process)
+}
+
+// ListenTask 监听任务连接通道数据（.fa）
+func (s *Session) ListenTask(conn io.ReadWriteCloser) {
+	_ = s.ServeTask(context.Background(), conn)
+}
+
+// ServeTask 监听任务连接通道数据（.fa），直到连接断开或 ctx 取消，返回 *ListenError 说明停止原因
+func (s *Session) ServeTask(ctx context.Context, conn io.ReadWriteCloser) error {
+	return s.serve(ctx, &servedConn{conn: conn, channel: ChannelTask}, s.newLegacyReader(ChannelTask).process)
+}
+
+// legacyReader 旧版通道（.fa、.fa2）的流式读取状态
+type legacyReader struct {
+	s           *Session
+	channel     Channel
+	receivedBuf []byte // 未处理的数据
+}
+
+// newLegacyReader 创建旧版通道读取状态
+func (s *Session) newLegacyReader(channel Channel) *legacyReader {
+	return &legacyReader{s: s, channel: channel}
+}
+
+// buffered 未处理的字节数，为 0 时下一块数据从帧边界开始
+func (r *legacyReader) buffered() int {
+	return len(r.receivedBuf)
+}
+
+// process 处理从连接读取的一块数据
+func (r *legacyReader) process(buf []byte) {
+	s := r.s
+	// 过滤无效数据包，只在帧边界判断数据类型
+	if len(r.receivedBuf) == 0 && !isLegacyPacketType(r.channel, buf[0]) {
+		return
+	}
+	r.receivedBuf = append(r.receivedBuf, buf...)
+
+	for len(r.receivedBuf) > 0 {
+		// 兼容旧版本心跳采集数据：数据采集通道帧边界处的短帧
+		if r.channel == ChannelCollect {
+			short, ok := collectShortFrame(r.receivedBuf)
+			if !ok {
+				break // 数据不足以判断帧格式，继续等待接收
 			}
-			// 处理传输结束数据
-			if dataStatus == protocol.DataEnd {
-				err = handleEndPacket(packet, taskID, totalLen, kvmID, processCompleteTaskData)
+			if short {
+				frame, n, err := protocol.DecodeLegacyShortFrame(r.receivedBuf)
 				if err != nil {
-					gvaLog.Error("解析数据错误:", zap.Error(err))
-					continue
+					break // 数据不完整，继续等待接收
 				}
+				r.receivedBuf = r.receivedBuf[n:]
+				atomic.AddUint64(&s.packetsReceived, 1)
+				s.handle(Message{KvmID: s.kvmID, Channel: ChannelCollect, PacketType: int(frame.PacketType), Data: frame.Data})
+				continue
 			}
 		}
+		if len(r.receivedBuf) < BufSize {
+			break
+		}
+		// 逐帧处理，数据采集通道的下一帧可能是短帧
+		s.processLegacyFrame(r.receivedBuf[:BufSize], r.channel)
+		r.receivedBuf = r.receivedBuf[BufSize:]
+	}
+	if len(r.receivedBuf) == 0 {
+		r.receivedBuf = nil
 	}
 }
 
-// ListenTaskConnection 监听任务连接通道数据（.fa）
-func ListenTaskConnection(conn net.Conn, kvmID string, log *zap.Logger, processCompleteTaskData ProcessCompleteDataFunc) {
-	defer conn.Close()
-	gvaLog = log
+// collectShortFrame 数据采集通道帧边界处的数据是否为旧版心跳短帧，ok 为 false 表示需等待更多数据才能判断。
+//
+// 短帧的 16 位长度可能恰好像定长帧有效的任务ID与状态（如长度 256、513），不能只看头部：
+// 短帧数据为 JSON，第 4 字节为 '{'，而定长帧该字节为 24 位总长度的最高字节，为 '{' 时总长度超过 8MB，
+// 旧版数据采集不会出现。头部不像定长帧的数据不足定长帧时同样按短帧解析，与旧版行为一致
+func collectShortFrame(b []byte) (short, ok bool) {
+	switch {
+	case len(b) < protocol.LegacyShortHeader:
+		return false, false
+	case !protocol.IsLegacyFrameHeader(b):
+		return len(b) < BufSize || b[protocol.LegacyShortHeader] == '{', true
+	case len(b) == protocol.LegacyShortHeader:
+		return false, false
+	default:
+		return b[protocol.LegacyShortHeader] == '{', true
+	}
+}
 
-	var receivedBuf []byte
-	for {
-		buf := make([]byte, BufSize)
-		n, err := conn.Read(buf)
+// isLegacyPacketType 旧版通道的有效数据类型
+func isLegacyPacketType(channel Channel, packetType byte) bool {
+	if channel == ChannelTask {
+		return int(packetType) == global.OldAgentBackCollect || int(packetType) == global.TaskCallBackCollect
+	}
+	return int(packetType) == global.TaskCollect || int(packetType) == global.MetricCollect
+}
+
+// processLegacyFrame 处理旧版通道（.fa、.fa2）的一个定长帧，raw 长度为 BufSize；
+// 任务通道中旧版探针的业务回调数据（OldAgentBackCollect）为填充至定长的短帧
+func (s *Session) processLegacyFrame(raw []byte, channel Channel) {
+	var out outbox
+	defer s.dispatch(&out)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// 兼容旧版本任务数据
+	if channel == ChannelTask && int(raw[0]) == global.OldAgentBackCollect {
+		frame, err := protocol.DecodePaddedLegacyShortFrame(raw)
 		if err != nil {
-			gvaLog.Error("Error reading from socket:", zap.Error(err))
-			gvaLog.Info(fmt.Sprintf("agent[%s].fa 连接断开", kvmID))
+			atomic.AddUint64(&s.packetsDropped, 1)
+			out.events = append(out.events, Event{Kind: EventMalformed, Channel: channel, Err: err})
 			return
 		}
+		atomic.AddUint64(&s.packetsReceived, 1)
+		out.messages = append(out.messages, Message{KvmID: s.kvmID, Channel: channel, PacketType: int(frame.PacketType), Data: frame.Data})
+		return
+	}
 
-		// 过滤无效数据包
-		if int(buf[0]) != global.OldAgentBackCollect && int(buf[0]) != global.TaskCallBackCollect {
-			continue
-		}
+	var frame protocol.LegacyFrame
+	if err := frame.UnmarshalBinary(raw); err != nil {
+		atomic.AddUint64(&s.packetsDropped, 1)
+		out.events = append(out.events, Event{Kind: EventMalformed, Channel: channel, TaskID: int(raw[1]), Err: err})
+		return
+	}
+	atomic.AddUint64(&s.packetsReceived, 1)
+	s.reassembleLegacyFrame(frame, channel, &out)
+}
 
-		receivedBuf = append(receivedBuf, buf[:n]...)
-		// 兼容旧版本任务数据
-		for len(receivedBuf) >= 3 && len(receivedBuf) == BufSize && int(receivedBuf[0]) == global.OldAgentBackCollect {
-			packetLen := int(receivedBuf[1])<<8 | int(receivedBuf[2])
-			totalLen := 3 + packetLen
-
-			packetType := int(receivedBuf[0])
-			data := receivedBuf[3:totalLen]
-			// 移除已处理的数据
-			receivedBuf = receivedBuf[4096:]
-			// 解析完整数据
-			err = processCompleteTaskData(packetType, data, kvmID)
-			if err != nil {
-				gvaLog.Error("解析数据错误:", zap.Error(err))
-				continue
-			}
+// reassembleLegacyFrame 按数据状态重组旧版定长帧，分帧规则见 protocol.LegacyFrame，调用方须持有 s.mu
+func (s *Session) reassembleLegacyFrame(frame protocol.LegacyFrame, channel Channel, out *outbox) {
+	key := bufferKey{channel: channel, taskID: int(frame.TaskID)}
+	buf, exists := s.buffers[key]
+	// 跳过已被淘汰数据的剩余数据帧
+	if exists && buf.evicted && int(frame.Status) != protocol.DataStart {
+		atomic.AddUint64(&s.packetsDropped, 1)
+		if int(frame.Status) == protocol.DataEnd {
+			s.removeBuffer(key)
 		}
+		return
+	}
 
-		// 处理接收的数据包
-		for len(receivedBuf) >= BufSize {
-			// 解析数据包头部信息
-			packet := receivedBuf[:BufSize]
-			receivedBuf = receivedBuf[BufSize:]
-			dataLen := int(packet[3])<<16 | int(packet[4])<<8 | int(packet[5])
-			totalLen := 6 + dataLen
-			dataStatus := int(packet[2])
-			taskID := int(packet[1])
-			// 过滤无效数据包
-			if !isValidPacket(taskID, dataStatus) {
-				continue
-			}
-			// 开始接收数据，添加数据到缓存中
-			if dataStatus == protocol.DataStart {
-				taskDataMapMutex.Lock()
-				if _, ok := taskDataMap[taskID]; ok {
-					delete(taskDataMap, taskID)
-				}
-				taskDataMap[taskID] = append(taskDataMap[taskID], packet...)
-				taskDataMapMutex.Unlock()
-			}
-			// 跳过不完整数据包
-			taskDataMapMutex.Lock()
-			if _, ok := taskDataMap[taskID]; !ok && totalLen >= BufSize {
-				taskDataMapMutex.Unlock()
-				continue
-			}
-			taskDataMapMutex.Unlock()
-			// 处理传输中数据，追加数据到缓存中
-			if dataStatus == protocol.DataTransfer {
-				taskDataMapMutex.Lock()
-				taskDataMap[taskID] = append(taskDataMap[taskID], packet[6:]...)
-				taskDataMapMutex.Unlock()
-			}
-			// 处理传输结束数据
-			if dataStatus == protocol.DataEnd {
-				err = handleEndTaskPacket(packet, taskID, totalLen, kvmID, processCompleteTaskData)
-				if err != nil {
-					gvaLog.Error("解析数据错误:", zap.Error(err))
-					continue
-				}
+	switch int(frame.Status) {
+	case protocol.DataStart:
+		s.startBuffer(key, frame.Chunk(0), 0, out)
+	case protocol.DataTransfer:
+		if !exists {
+			atomic.AddUint64(&s.packetsDropped, 1)
+			return
+		}
+		s.grow(key, buf, frame.Chunk(len(buf.Data)), out)
+	case protocol.DataEnd:
+		if !exists {
+			// 单帧数据，超出单帧长度说明缺少前面的数据帧
+			if frame.TotalLen > protocol.LegacyMaxPayload {
+				atomic.AddUint64(&s.packetsDropped, 1)
+				return
 			}
+			out.messages = append(out.messages, Message{KvmID: s.kvmID, Channel: channel, PacketType: int(frame.PacketType), TaskID: key.taskID, Data: frame.Chunk(0)})
+			return
+		}
+		chunk := frame.Chunk(len(buf.Data))
+		if len(buf.Data)+len(chunk) != int(frame.TotalLen) {
+			atomic.AddUint64(&s.packetsDropped, 1)
+			out.events = append(out.events, Event{Kind: EventMalformed, Channel: channel, TaskID: key.taskID, Err: ErrLengthMismatch})
+			s.dropBuffer(key, ErrLengthMismatch, out)
+			return
 		}
+		if !s.grow(key, buf, chunk, out) {
+			return
+		}
+		s.removeBuffer(key)
+		out.messages = append(out.messages, Message{KvmID: s.kvmID, Channel: channel, PacketType: int(frame.PacketType), TaskID: key.taskID, Data: buf.Data})
 	}
 }
 
-// ListenSerialConnection 监听物理串口连接通道数据（.fa00）
-func ListenSerialConnection(conn net.Conn, kvmID string, log *zap.Logger, processCompleteTaskData ProcessCompleteDataFunc) {
-	defer conn.Close()
-	gvaLog = log
+// ListenSerial 监听物理串口连接通道数据（.fa00）
+func (s *Session) ListenSerial(conn io.ReadWriteCloser) {
+	_ = s.ServeSerial(context.Background(), conn)
+}
 
-	var receivedBuf []byte
+// ServeSerial 监听物理串口连接通道数据（.fa00），直到连接断开或 ctx 取消，返回 *ListenError 说明停止原因
+func (s *Session) ServeSerial(ctx context.Context, conn io.ReadWriteCloser) error {
+	s.resetSequence()
+	return s.serve(ctx, &servedConn{conn: conn, channel: ChannelSerial}, s.newSerialReader().process)
+}
+
+// serialReader 物理串口通道（0xCAFE 协议）的流式读取状态
+type serialReader struct {
+	s       *Session
+	decoder *protocol.Decoder
+}
+
+// newSerialReader 创建物理串口通道读取状态
+func (s *Session) newSerialReader() *serialReader {
+	decoder := protocol.NewDecoder(nil)
+	decoder.SetMaxDataLen(s.maxFrameSize)
+	return &serialReader{s: s, decoder: decoder}
+}
+
+// process 处理从连接读取的一块数据
+func (r *serialReader) process(buf []byte) {
+	s := r.s
+	r.decoder.Feed(buf)
+	// 处理所有完整的数据包
 	for {
-		buf := make([]byte, BufSize)
-		n, err := conn.Read(buf)
-		if err != nil {
-			log.Error("Error reading from socket:", zap.Error(err))
-			log.Info(fmt.Sprintf("agent[%s].fa00 连接断开", kvmID))
-			// 清理所有未完成的数据包
-			packetBuffersMutex.Lock()
-			packetBuffers = make(map[byte]*protocol.PacketBuffer)
-			packetBuffersMutex.Unlock()
+		packet, err := r.decoder.Decode()
+		if err == io.EOF {
 			return
 		}
+		if err != nil {
+			atomic.AddUint64(&s.packetsDropped, 1)
+			s.emit(frameEvent(err))
+			continue
+		}
+		if err = s.open(packet); err != nil {
+			atomic.AddUint64(&s.packetsDropped, 1)
+			s.emit(securityEvent(packet.Header, err))
+			continue
+		}
+		if !s.checkSequence(packet.Header) {
+			continue
+		}
+		s.handleSerialPacket(packet)
+	}
+}
 
-		receivedBuf = append(receivedBuf, buf[:n]...)
+// open 配置加密时校验并解密数据包，未加密的 Hello 用于交换派生帧密钥的随机数，直接接受
+func (s *Session) open(packet *protocol.DataPacket) error {
+	if s.sealer == nil || isPlainHello(packet.Header) {
+		return nil
+	}
+	return s.sealer.Open(packet)
+}
 
-		// 处理所有完整的数据包
-		for len(receivedBuf) >= MinPacketSize {
-			// 查找Magic Number
-			magicIndex := -1
-			for i := 0; i <= len(receivedBuf)-2; i++ {
-				if binary.BigEndian.Uint16(receivedBuf[i:i+2]) == MagicNumber {
-					magicIndex = i
-					break
-				}
-			}
+// handleSerialPacket 按数据状态重组物理串口通道的数据包，分帧规则见 protocol.DataStart
+func (s *Session) handleSerialPacket(packet *protocol.DataPacket) {
+	header := packet.Header
+	atomic.AddUint64(&s.packetsReceived, 1)
+	if protocol.IsControl(header.PacketType) {
+		s.handleControl(packet)
+		return
+	}
 
-			if magicIndex == -1 || len(receivedBuf[magicIndex:]) < MinPacketSize {
-				break
-			}
+	var out outbox
+	s.mu.Lock()
+	s.reassembleSerialPacket(packet, &out)
+	s.mu.Unlock()
+	s.dispatch(&out)
+}
 
-			// 解析header
-			header := protocol.PacketHeader{
-				MagicNumber: binary.BigEndian.Uint16(receivedBuf[magicIndex:]),
-				Version:     receivedBuf[magicIndex+2],
-				SeqNum:      binary.BigEndian.Uint32(receivedBuf[magicIndex+3:]),
-				PacketType:  receivedBuf[magicIndex+7],
-				Status:      receivedBuf[magicIndex+8],
-				TaskID:      receivedBuf[magicIndex+9],
-				DataLen:     binary.BigEndian.Uint16(receivedBuf[magicIndex+10:]),
+// reassembleSerialPacket 将数据包加入重组缓存，调用方须持有 s.mu
+func (s *Session) reassembleSerialPacket(packet *protocol.DataPacket, out *outbox) {
+	header := packet.Header
+	key := bufferKey{channel: ChannelSerial, taskID: int(header.TaskID)}
+	if s.reliable && s.redelivered(key, header) {
+		return
+	}
+	buf, exists := s.buffers[key]
+	switch int(header.Status) {
+	case protocol.DataStart:
+		if exists && !buf.evicted && buf.first == header.SeqNum {
+			// 等待 Ack 超时后重发的首帧，保留已收到的数据
+			atomic.AddUint64(&s.packetsDropped, 1)
+			return
+		}
+		// 同一任务ID重新开始，之前未完成的数据无法再完成
+		delete(s.delivered, key)
+		if buf = s.startBuffer(key, packet.Data, header.SeqNum, out); buf != nil {
+			buf.flags = header.Flags
+		}
+	case protocol.DataTransfer, protocol.DataEnd:
+		if exists && buf.evicted {
+			// 已被淘汰数据的剩余数据包
+			atomic.AddUint64(&s.packetsDropped, 1)
+			if int(header.Status) == protocol.DataEnd {
+				s.removeBuffer(key)
 			}
-
-			// 验证版本
-			if header.Version != ProtocolVersion {
-				receivedBuf = receivedBuf[magicIndex+1:]
-				continue
+			return
+		}
+		if !exists {
+			// 单片数据（含空数据）直接处理
+			if int(header.Status) == protocol.DataEnd {
+				s.complete(header, header.SeqNum, header.Flags, packet.Data, out)
+				return
 			}
-
-			totalLen := HeaderSize + int(header.DataLen) + CRCSize
-			if len(receivedBuf[magicIndex:]) < totalLen {
-				break
+			// 首片已丢失，丢弃该数据剩余的数据包
+			atomic.AddUint64(&s.packetsDropped, 1)
+			s.tombstone(key, time.Now())
+			return
+		}
+		if header.SeqNum != buf.LastSeq+1 {
+			if s.reliable && s.deferSerialPacket(key, buf, packet, out) {
+				return
 			}
+			atomic.AddUint64(&s.packetsDropped, 1)
+			out.events = append(out.events, Event{Kind: EventSequenceGap, Channel: ChannelSerial, TaskID: key.taskID, SeqNum: header.SeqNum,
+				Err: fmt.Errorf("%w（期望序列号: %d）", ErrSequenceGap, buf.LastSeq+1)})
+			s.dropBuffer(key, ErrSequenceGap, out)
+			s.tombstone(key, time.Now())
+			return
+		}
+		s.appendSerialPacket(key, buf, packet, out)
+	default:
+		atomic.AddUint64(&s.packetsDropped, 1)
+	}
+}
 
-			packet := receivedBuf[magicIndex : magicIndex+totalLen]
+// appendSerialPacket 追加序列号连续的数据包，可靠传输时继续追加已缓存的后续乱序包，
+// 收到最后一片时交付完整数据，调用方须持有 s.mu
+func (s *Session) appendSerialPacket(key bufferKey, buf *messageBuffer, packet *protocol.DataPacket, out *outbox) {
+	for packet != nil {
+		if !s.grow(key, buf, packet.Data, out) {
+			return
+		}
+		buf.LastSeq = packet.Header.SeqNum
+		if int(packet.Header.Status) == protocol.DataEnd {
+			// 多片数据的最后一片，清理缓存并处理完整数据
+			buf.Complete = true
+			s.removeBuffer(key)
+			s.complete(packet.Header, buf.first, buf.flags, buf.Data, out)
+			return
+		}
+		next, ok := buf.pending[buf.LastSeq+1]
+		if !ok {
+			return
+		}
+		delete(buf.pending, buf.LastSeq+1)
+		buf.pendingBytes -= len(next.Data)
+		s.buffered -= len(next.Data)
+		packet = next
+	}
+}
 
-			// 验证CRC
-			expectedCRC := binary.BigEndian.Uint32(packet[totalLen-CRCSize:])
-			actualCRC := crc32.ChecksumIEEE(packet[:totalLen-CRCSize])
-			if expectedCRC != actualCRC {
-				receivedBuf = receivedBuf[magicIndex+1:]
-				continue
+// deferSerialPacket 可靠传输时缓存乱序到达的数据包，并对新发现的缺失帧发送 Nack；
+// 重复的数据包直接忽略，收到重发的最后一帧时再次请求仍缺失的帧（之前的 Nack 或重传可能丢失）。
+// 缺失的帧超过单个 Nack 能请求的数量时返回 false，由调用方按序列号不连续处理，调用方须持有 s.mu
+func (s *Session) deferSerialPacket(key bufferKey, buf *messageBuffer, packet *protocol.DataPacket, out *outbox) bool {
+	seq := packet.Header.SeqNum
+	// 序列号按 uint32 回绕比较
+	if int32(seq-buf.LastSeq) <= 0 {
+		atomic.AddUint64(&s.packetsDropped, 1)
+		return true
+	}
+	if _, ok := buf.pending[seq]; ok {
+		atomic.AddUint64(&s.packetsDropped, 1)
+		if seq == buf.highest {
+			if missing := buf.missing(); len(missing) > 0 {
+				go s.sendControl(protocol.PacketTypeNack, packet.Header.TaskID, protocol.EncodeNack(missing))
 			}
-
-			// 处理数据包
-			packetBuffersMutex.Lock()
-			switch int(header.Status) {
-			case protocol.DataStart:
-				packetBuffers[header.TaskID] = &protocol.PacketBuffer{
-					Data:    packet[HeaderSize : totalLen-CRCSize],
-					LastSeq: header.SeqNum,
-				}
-			case protocol.DataTransfer:
-				if buf, exists := packetBuffers[header.TaskID]; exists && header.SeqNum == buf.LastSeq+1 {
-					buf.Data = append(buf.Data, packet[HeaderSize:totalLen-CRCSize]...)
-					buf.LastSeq = header.SeqNum
-				}
-			case protocol.DataEnd:
-				if _, exists := packetBuffers[header.TaskID]; !exists {
-					// 单片数据，直接处理
-					if err := processCompleteTaskData(int(header.PacketType), packet[HeaderSize:totalLen-CRCSize], kvmID); err != nil {
-						log.Error("处理数据失败:", zap.Error(err))
-					}
-				} else {
-					// 多片数据的最后一片
-					if buf, exists := packetBuffers[header.TaskID]; exists && header.SeqNum == buf.LastSeq+1 {
-						buf.Data = append(buf.Data, packet[HeaderSize:totalLen-CRCSize]...)
-						buf.Complete = true
-
-						// 处理完整数据
-						if err := processCompleteTaskData(int(header.PacketType), buf.Data, kvmID); err != nil {
-							log.Error("处理数据失败:", zap.Error(err))
-						}
-
-						// 清理缓存
-						delete(packetBuffers, header.TaskID)
-					}
-				}
-
+		}
+		return true
+	}
+	// 已缓存的乱序帧都在 LastSeq 与 seq 之间，其余的帧都需要请求重传
+	if int32(seq-buf.highest) > 0 && int(seq-buf.LastSeq-1)-len(buf.pending) > protocol.MaxNackSeqs {
+		return false
+	}
+	// 乱序数据同样计入单条长度与内存预算
+	buf.lastActive = time.Now()
+	if s.maxMessageSize > 0 && buf.size()+len(packet.Data) > s.maxMessageSize {
+		s.evict(key, ErrMessageTooLarge, out)
+		return true
+	}
+	if !s.reserve(key, len(packet.Data), out) {
+		return true
+	}
+	if buf.pending == nil {
+		buf.pending = make(map[uint32]*protocol.DataPacket)
+	}
+	buf.pending[seq] = packet
+	buf.pendingBytes += len(packet.Data)
+	s.buffered += len(packet.Data)
+
+	// 只请求新发现的缺失帧，已请求过的等待重传
+	if int32(seq-buf.highest) > 0 {
+		var missing []uint32
+		for missed := buf.highest + 1; missed != seq; missed++ {
+			if _, ok := buf.pending[missed]; !ok && int32(missed-buf.LastSeq) > 0 {
+				missing = append(missing, missed)
 			}
-			packetBuffersMutex.Unlock()
-
-			// 移除已处理的数据
-			receivedBuf = receivedBuf[magicIndex+totalLen:]
+		}
+		buf.highest = seq
+		if len(missing) > 0 {
+			go s.sendControl(protocol.PacketTypeNack, packet.Header.TaskID, protocol.EncodeNack(missing))
 		}
 	}
+	return true
 }
 
-// 校验数据包格式
-func isValidPacket(taskID int, dataStatus int) bool {
-	if taskID < 255 && taskID > 0 && (dataStatus == protocol.DataStart || dataStatus == protocol.DataTransfer || dataStatus == protocol.DataEnd) {
-		return true
+// redelivered 可靠传输时识别已交付数据的重发帧（对端未收到 Ack），丢弃并在收到最后一帧时重新发送 Ack，调用方须持有 s.mu
+func (s *Session) redelivered(key bufferKey, header protocol.PacketHeader) bool {
+	r, ok := s.delivered[key]
+	// 序列号按 uint32 回绕比较
+	if !ok || int32(header.SeqNum-r.first) < 0 || int32(header.SeqNum-r.last) > 0 {
+		return false
+	}
+	atomic.AddUint64(&s.packetsDropped, 1)
+	if header.SeqNum == r.last {
+		go s.sendControl(protocol.PacketTypeAck, header.TaskID, protocol.EncodeAck(header.SeqNum))
 	}
-	return false
+	return true
 }
 
-// handleEndPacket 处理数据结束包
-func handleEndPacket(packet []byte, taskID int, totalLen int, kvmID string, processCompleteAgentDataData ProcessCompleteDataFunc) error {
-	actualDataEnd := totalLen - len(collectTaskDataMap[taskID]) + 6
-	if actualDataEnd > BufSize {
-		actualDataEnd = BufSize
-	}
-	collectTaskDataMapMutex.Lock()
-	if totalLen <= BufSize {
-		collectTaskDataMap[taskID] = append(collectTaskDataMap[taskID], packet[:totalLen]...)
-	} else {
-		collectTaskDataMap[taskID] = append(collectTaskDataMap[taskID], packet[6:actualDataEnd]...)
+// complete 记录重组完成的数据，first 为首帧的序列号，压缩的数据先解压，可靠传输时向对端发送 Ack，调用方须持有 s.mu
+func (s *Session) complete(header protocol.PacketHeader, first uint32, flags byte, data []byte, out *outbox) {
+	if s.reliable {
+		s.delivered[bufferKey{channel: ChannelSerial, taskID: int(header.TaskID)}] = seqRange{first: first, last: header.SeqNum}
+		go s.sendControl(protocol.PacketTypeAck, header.TaskID, protocol.EncodeAck(header.SeqNum))
 	}
-	collectTaskData := collectTaskDataMap[taskID]
-	packetType := int(collectTaskData[0])
-	data := collectTaskData[6:]
-	// 清除已处理的数据包
-	delete(collectTaskDataMap, taskID)
-	collectTaskDataMapMutex.Unlock()
-	// 解析完整数据
-	err := processCompleteAgentDataData(packetType, data, kvmID)
-	if err != nil {
-		return err
+	if flags&protocol.FlagCompressed != 0 {
+		decompressed, err := protocol.Decompress(data, s.maxMessageSize)
+		if err != nil {
+			kind := EventMalformed
+			if errors.Is(err, protocol.ErrDecompressedTooLarge) {
+				kind = EventOversize
+			}
+			atomic.AddUint64(&s.packetsDropped, 1)
+			out.events = append(out.events, Event{Kind: kind, Channel: ChannelSerial, TaskID: int(header.TaskID), SeqNum: header.SeqNum, Err: err})
+			return
+		}
+		data = decompressed
 	}
-	return nil
+	out.messages = append(out.messages, Message{
+		KvmID:      s.kvmID,
+		Channel:    ChannelSerial,
+		PacketType: int(header.PacketType),
+		TaskID:     int(header.TaskID),
+		SeqNum:     header.SeqNum,
+		Data:       data,
+	})
 }
 
-// handleEndPacket 处理数据结束包
-func handleEndTaskPacket(packet []byte, taskID int, totalLen int, kvmID string, processCompleteTaskData ProcessCompleteDataFunc) error {
-	actualDataEnd := totalLen - len(taskDataMap[taskID]) + 6
-	if actualDataEnd > BufSize {
-		actualDataEnd = BufSize
+// handleControl 处理对端的握手数据包，Ack/Nack 交给写入器处理；
+// 重传需等待正在进行的分片写入，在独立的 goroutine 中处理，不阻塞读取
+func (s *Session) handleControl(packet *protocol.DataPacket) {
+	if packet.Header.PacketType == protocol.PacketTypeHello {
+		s.handleHello(packet)
+		return
 	}
-	taskDataMapMutex.Lock()
-	if totalLen <= BufSize {
-		taskDataMap[taskID] = append(taskDataMap[taskID], packet[:totalLen]...)
-	} else {
-		taskDataMap[taskID] = append(taskDataMap[taskID], packet[6:actualDataEnd]...)
+	if s.writer == nil {
+		return
 	}
-	taskData := taskDataMap[taskID]
-	packetType := int(taskData[0])
-	data := taskData[6:]
-	// 清除已处理的数据包
-	delete(taskDataMap, taskID)
-	taskDataMapMutex.Unlock()
-	// 解析完整数据
-	err := processCompleteTaskData(packetType, data, kvmID)
-	if err != nil {
-		return err
+	go func() {
+		if err := s.writer.HandleControl(packet); err != nil {
+			s.log.Warn("处理控制数据包失败", zap.Int("taskID", int(packet.Header.TaskID)), zap.Error(err))
+		}
+	}()
+}
+
+// sendControl 向对端发送控制数据包
+func (s *Session) sendControl(packetType byte, taskID uint16, data []byte) {
+	if err := s.writer.WriteControl(packetType, int(taskID), data); err != nil {
+		s.log.Warn("发送控制数据包失败", zap.Int("taskID", int(taskID)), zap.Error(err))
 	}
-	return nil
 }
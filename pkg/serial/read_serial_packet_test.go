@@ -0,0 +1,368 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/global"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+)
+
+// frameRecorder 按 Write 调用记录写入器发送的每一帧
+type frameRecorder struct {
+	frames [][]byte
+}
+
+func (r *frameRecorder) Write(p []byte) (int, error) {
+	r.frames = append(r.frames, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+// writeFrames 使用同一写入器按默认分片大小依次写入各条数据，返回各帧
+func writeFrames(t *testing.T, taskID int, data ...[]byte) [][]byte {
+	t.Helper()
+	rec := &frameRecorder{}
+	w, err := NewWriter(rec, nil, WriterConfig{Pacer: NoPacer, CompressThreshold: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range data {
+		if err = w.WriteTask(1, taskID, d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.frames
+}
+
+// newTestSession 创建记录完整数据与被丢弃数据的会话
+func newTestSession(messages *[]Message, partials *[]PartialMessage) *Session {
+	return NewSession("test", SessionConfig{
+		Handler: func(msg Message) error {
+			*messages = append(*messages, msg)
+			return nil
+		},
+		OnDiscard: func(partial PartialMessage) {
+			*partials = append(*partials, partial)
+		},
+		OnEvent: func(Event) {},
+	})
+}
+
+func payload(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i*7 + 3)
+	}
+	return data
+}
+
+func TestSerialRoundTrip(t *testing.T) {
+	tests := []struct {
+		size   int
+		frames int
+	}{
+		{size: 0, frames: 1},
+		{size: 1, frames: 1},
+		{size: 300, frames: 1},
+		{size: 301, frames: 2},
+		{size: 600, frames: 2},
+		{size: 601, frames: 3},
+	}
+	for _, tt := range tests {
+		data := payload(tt.size)
+		frames := writeFrames(t, 5, data)
+		if len(frames) != tt.frames {
+			t.Errorf("size %d: got %d frames, want %d", tt.size, len(frames), tt.frames)
+		}
+
+		var messages []Message
+		var partials []PartialMessage
+		r := newTestSession(&messages, &partials).newSerialReader()
+		r.process(bytes.Join(frames, nil))
+
+		if len(messages) != 1 {
+			t.Fatalf("size %d: got %d messages, want 1", tt.size, len(messages))
+		}
+		if msg := messages[0]; msg.TaskID != 5 || msg.PacketType != 1 || !bytes.Equal(msg.Data, data) {
+			t.Errorf("size %d: got task %d type %d len %d", tt.size, msg.TaskID, msg.PacketType, len(msg.Data))
+		}
+		if len(partials) != 0 {
+			t.Errorf("size %d: got %d partials", tt.size, len(partials))
+		}
+	}
+}
+
+func TestSerialSequenceGap(t *testing.T) {
+	tests := []struct {
+		name    string
+		drop    int // 丢弃的帧
+		partial int // 被丢弃的未完成数据条数
+	}{
+		{name: "first", drop: 0, partial: 0},
+		{name: "middle", drop: 5, partial: 1},
+		{name: "last", drop: 9, partial: 1},
+	}
+	for _, tt := range tests {
+		next := payload(400)
+		frames := writeFrames(t, 7, payload(3000), next)
+		if len(frames) != 12 {
+			t.Fatalf("got %d frames, want 12", len(frames))
+		}
+		frames = append(frames[:tt.drop], frames[tt.drop+1:]...)
+
+		var messages []Message
+		var partials []PartialMessage
+		s := newTestSession(&messages, &partials)
+		s.newSerialReader().process(bytes.Join(frames, nil))
+
+		// 缺帧的数据不能交付，同一任务ID的下一条数据正常交付
+		if len(messages) != 1 || !bytes.Equal(messages[0].Data, next) {
+			t.Errorf("%s: got %d messages, want only the following message", tt.name, len(messages))
+		}
+		if len(partials) != tt.partial {
+			t.Errorf("%s: got %d partials, want %d", tt.name, len(partials), tt.partial)
+		}
+		if n := s.Stats().BufferedBytes; n != 0 {
+			t.Errorf("%s: %d bytes still buffered", tt.name, n)
+		}
+	}
+}
+
+// lossyLink 将写入的帧交给对端的读取器处理，丢弃指定序号的 Write 调用
+type lossyLink struct {
+	mu     sync.Mutex
+	writes int
+	drop   int
+	reader *serialReader
+}
+
+func (l *lossyLink) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.writes++
+	if l.writes-1 != l.drop {
+		l.reader.process(append([]byte(nil), p...))
+	}
+	return len(p), nil
+}
+
+func TestSerialAckTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		drop    int // 发送方丢弃的帧
+		dropAck int // 接收方丢弃的 Ack
+	}{
+		{name: "first", drop: 0, dropAck: -1},
+		{name: "middle", drop: 4, dropAck: -1},
+		{name: "last", drop: 9, dropAck: -1},
+		{name: "ack", drop: -1, dropAck: 0},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		var messages []Message
+		toReceiver := &lossyLink{drop: tt.drop}
+		toSender := &lossyLink{drop: tt.dropAck}
+		sender, err := NewWriter(toReceiver, nil, WriterConfig{Pacer: NoPacer, CompressThreshold: -1, RetransmitWindow: 16, AckTimeout: 50 * time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		replier, err := NewWriter(toSender, nil, WriterConfig{Pacer: NoPacer})
+		if err != nil {
+			t.Fatal(err)
+		}
+		toReceiver.reader = NewSession("receiver", SessionConfig{Writer: replier, Reliable: true, OnEvent: func(Event) {}, Handler: func(msg Message) error {
+			mu.Lock()
+			messages = append(messages, msg)
+			mu.Unlock()
+			return nil
+		}}).newSerialReader()
+		toSender.reader = NewSession("sender", SessionConfig{Writer: sender, Reliable: true}).newSerialReader()
+		if err = sender.SetNegotiated(ProtocolVersion, protocol.CapReliable); err != nil {
+			t.Fatal(err)
+		}
+
+		data := payload(3000)
+		if err = sender.WriteTask(1, 7, data); err != nil {
+			t.Fatal(err)
+		}
+		// 丢帧或丢失 Ack 后由发送方超时重发补齐，数据只交付一次，窗口随 Ack 释放
+		deadline := time.Now().Add(2 * time.Second)
+		for {
+			sender.windowMu.Lock()
+			pending := len(sender.window) + len(sender.awaiting)
+			sender.windowMu.Unlock()
+			mu.Lock()
+			delivered := len(messages)
+			mu.Unlock()
+			if pending == 0 && delivered > 0 || time.Now().After(deadline) {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		mu.Lock()
+		if len(messages) != 1 || !bytes.Equal(messages[0].Data, data) {
+			t.Errorf("%s: got %d messages, want 1", tt.name, len(messages))
+		}
+		mu.Unlock()
+		sender.windowMu.Lock()
+		if n := len(sender.window); n != 0 {
+			t.Errorf("%s: %d tasks still in retransmit window", tt.name, n)
+		}
+		sender.windowMu.Unlock()
+	}
+}
+
+func TestSerialNackLargeMessage(t *testing.T) {
+	var mu sync.Mutex
+	var messages []Message
+	var events []Event
+	toReceiver := &lossyLink{drop: 5}
+	toSender := &lossyLink{drop: -1}
+	// Ack 超时足够长，丢失的帧只能由 Nack 补齐
+	sender, err := NewWriter(toReceiver, nil, WriterConfig{Pacer: NoPacer, CompressThreshold: -1, RetransmitWindow: 16, AckTimeout: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replier, err := NewWriter(toSender, nil, WriterConfig{Pacer: NoPacer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	toReceiver.reader = NewSession("receiver", SessionConfig{Writer: replier, Reliable: true,
+		OnEvent: func(event Event) {
+			mu.Lock()
+			events = append(events, event)
+			mu.Unlock()
+		},
+		Handler: func(msg Message) error {
+			mu.Lock()
+			messages = append(messages, msg)
+			mu.Unlock()
+			return nil
+		}}).newSerialReader()
+	toSender.reader = NewSession("sender", SessionConfig{Writer: sender, Reliable: true}).newSerialReader()
+	if err = sender.SetNegotiated(ProtocolVersion, protocol.CapReliable); err != nil {
+		t.Fatal(err)
+	}
+
+	// 100 帧的数据，缺失第 5 帧后缓存的乱序帧远超单个 Nack 的范围
+	data := payload(100 * DefaultMaxPayload)
+	if err = sender.WriteTask(1, 7, data); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		mu.Lock()
+		delivered := len(messages)
+		mu.Unlock()
+		if delivered > 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(messages) != 1 || !bytes.Equal(messages[0].Data, data) {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	for _, event := range events {
+		if event.Kind == EventSequenceGap {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	}
+}
+
+func TestSerialAckNotNegotiated(t *testing.T) {
+	// 对端未在握手中声明可靠传输（如旧版探针），不保留重传窗口也不等待 Ack
+	w, err := NewWriter(&frameRecorder{}, nil, WriterConfig{Pacer: NoPacer, RetransmitWindow: 16, AckTimeout: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.WriteTask(1, 7, payload(1000)); err != nil {
+		t.Fatal(err)
+	}
+	w.windowMu.Lock()
+	defer w.windowMu.Unlock()
+	if len(w.window) != 0 || len(w.awaiting) != 0 {
+		t.Fatalf("window %d tasks, awaiting %d tasks, want none", len(w.window), len(w.awaiting))
+	}
+}
+
+func TestSerialCorruptLength(t *testing.T) {
+	// v2 头部的长度字段损坏，声明远超单帧上限的数据长度
+	corrupt := make([]byte, protocol.HeaderSizeV2)
+	binary.BigEndian.PutUint16(corrupt, protocol.MagicNumber)
+	corrupt[2] = protocol.ProtocolVersion2
+	binary.BigEndian.PutUint32(corrupt[12:], 8<<20)
+
+	data := payload(100)
+	var messages []Message
+	var partials []PartialMessage
+	var events []Event
+	s := newTestSession(&messages, &partials)
+	s.onEvent = func(event Event) { events = append(events, event) }
+	s.newSerialReader().process(append(corrupt, bytes.Join(writeFrames(t, 3, data), nil)...))
+
+	// 损坏的帧立即跳过，后续数据帧不等待声明的长度
+	if len(messages) != 1 || !bytes.Equal(messages[0].Data, data) {
+		t.Fatalf("got %d messages, want 1", len(messages))
+	}
+	if len(events) != 1 || !errors.Is(events[0].Err, protocol.ErrDataTooLong) {
+		t.Errorf("got events %v, want one ErrDataTooLong", events)
+	}
+}
+
+// heartbeat 长度为 n 的 JSON 心跳数据
+func heartbeat(n int) []byte {
+	return []byte(`{"d":"` + strings.Repeat("a", n-8) + `"}`)
+}
+
+func TestCollectShortFrameAmbiguous(t *testing.T) {
+	var fixed bytes.Buffer
+	metric := heartbeat(700)
+	for _, taskID := range []byte{1, 2} {
+		if err := protocol.NewLegacyEncoder(&fixed).Encode(byte(global.MetricCollect), taskID, metric); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 256、258、513 的长度字段像定长帧头部，100、400 不像
+	for _, size := range []int{256, 258, 513, 100, 400} {
+		short, err := protocol.LegacyShortFrame{PacketType: byte(global.MetricCollect), Data: heartbeat(size)}.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stream := append(append(append([]byte(nil), short...), fixed.Bytes()...), short...)
+
+		// 整块到达与按读取缓存大小分块到达的结果相同
+		for _, chunk := range []int{len(stream), BufSize, 7} {
+			var messages []Message
+			var partials []PartialMessage
+			r := newTestSession(&messages, &partials).newLegacyReader(ChannelCollect)
+			for offset := 0; offset < len(stream); offset += chunk {
+				end := offset + chunk
+				if end > len(stream) {
+					end = len(stream)
+				}
+				r.process(stream[offset:end])
+			}
+
+			want := [][]byte{heartbeat(size), metric, metric, heartbeat(size)}
+			if len(messages) != len(want) {
+				t.Fatalf("size %d chunk %d: got %d messages, want %d", size, chunk, len(messages), len(want))
+			}
+			for i, msg := range messages {
+				if !bytes.Equal(msg.Data, want[i]) {
+					t.Errorf("size %d chunk %d: message %d has %d bytes, want %d", size, chunk, i, len(msg.Data), len(want[i]))
+				}
+			}
+			if r.buffered() != 0 || len(partials) != 0 {
+				t.Errorf("size %d chunk %d: %d bytes buffered, %d partials", size, chunk, r.buffered(), len(partials))
+			}
+		}
+	}
+}
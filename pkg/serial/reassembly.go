@@ -0,0 +1,218 @@
+package serial
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+)
+
+// 单帧长度与重组缓存默认限制
+const (
+	DefaultMaxFrameSize   = protocol.MaxDataLen // 单帧最大数据长度，与 v1 协议上限一致
+	DefaultIdleTimeout    = 2 * time.Minute
+	DefaultMaxMessageSize = 16 << 20
+	DefaultMemoryBudget   = 64 << 20
+)
+
+// 未完成数据被淘汰的原因
+var (
+	ErrIdleTimeout     = errors.New("未完成数据等待超时")
+	ErrMessageTooLarge = errors.New("重组数据超过最大长度")
+	ErrMemoryBudget    = errors.New("会话重组缓存超过内存预算")
+)
+
+// bufferKey 重组缓存键，各通道的任务ID相互独立
+type bufferKey struct {
+	channel Channel
+	taskID  int
+}
+
+// messageBuffer 重组中的数据
+type messageBuffer struct {
+	protocol.PacketBuffer
+	pending      map[uint32]*protocol.DataPacket // 乱序到达、等待缺失帧补齐的数据包（可靠传输）
+	pendingBytes int                             // pending 中数据占用的字节数
+	first        uint32                          // 首帧的序列号，用于识别重发的首帧
+	highest      uint32                          // 已收到的最大序列号
+	flags        byte                            // 首帧头部标志位（v2），如 FlagCompressed
+	lastActive   time.Time                       // 最后一次收到数据包的时间
+	evicted      bool                            // 已被淘汰或因丢包丢弃，丢弃该数据剩余的数据包直到重新开始
+}
+
+// size 缓存占用的字节数
+func (b *messageBuffer) size() int {
+	return len(b.Data) + b.pendingBytes
+}
+
+// missing 可靠传输时 LastSeq 与 highest 之间仍缺失的序列号，最多 protocol.MaxNackSeqs 个
+func (b *messageBuffer) missing() []uint32 {
+	var seqs []uint32
+	// 序列号按 uint32 回绕比较
+	for seq := b.LastSeq + 1; int32(b.highest-seq) > 0 && len(seqs) < protocol.MaxNackSeqs; seq++ {
+		if _, ok := b.pending[seq]; !ok {
+			seqs = append(seqs, seq)
+		}
+	}
+	return seqs
+}
+
+// outbox 单次处理产生的回调，在释放重组缓存锁后统一执行，避免回调阻塞其他通道
+type outbox struct {
+	messages []Message
+	partials []PartialMessage
+	events   []Event
+}
+
+// dispatch 执行处理期间产生的回调，调用方不得持有 s.mu
+func (s *Session) dispatch(out *outbox) {
+	for _, event := range out.events {
+		s.emit(event)
+	}
+	for _, partial := range out.partials {
+		s.discard(partial)
+	}
+	for _, msg := range out.messages {
+		s.handle(msg)
+	}
+}
+
+// startBuffer 开始重组一条数据，同一任务ID未完成的数据被丢弃，超出限制时返回 nil，调用方须持有 s.mu
+func (s *Session) startBuffer(key bufferKey, data []byte, seqNum uint32, out *outbox) *messageBuffer {
+	if _, exists := s.buffers[key]; exists {
+		s.dropBuffer(key, ErrMessageRestarted, out)
+	}
+	buf := &messageBuffer{first: seqNum, highest: seqNum}
+	buf.LastSeq = seqNum
+	s.buffers[key] = buf
+	if !s.grow(key, buf, data, out) {
+		return nil
+	}
+	return buf
+}
+
+// grow 向重组中的数据追加内容，超过单条最大长度或会话内存预算时淘汰数据并返回 false，调用方须持有 s.mu
+func (s *Session) grow(key bufferKey, buf *messageBuffer, data []byte, out *outbox) bool {
+	buf.lastActive = time.Now()
+	if s.maxMessageSize > 0 && buf.size()+len(data) > s.maxMessageSize {
+		s.evict(key, ErrMessageTooLarge, out)
+		return false
+	}
+	if !s.reserve(key, len(data), out) {
+		return false
+	}
+	buf.Data = append(buf.Data, data...)
+	s.buffered += len(data)
+	return true
+}
+
+// reserve 确保会话内存预算能容纳 n 字节，不足时按最久未活动的顺序淘汰其他数据，
+// 仍不足时淘汰 key 自身并返回 false，调用方须持有 s.mu
+func (s *Session) reserve(key bufferKey, n int, out *outbox) bool {
+	if s.memoryBudget <= 0 {
+		return true
+	}
+	for s.buffered+n > s.memoryBudget {
+		oldestKey, found := key, false
+		var oldest time.Time
+		for k, buf := range s.buffers {
+			if k != key && !buf.evicted && (!found || buf.lastActive.Before(oldest)) {
+				oldestKey, oldest, found = k, buf.lastActive, true
+			}
+		}
+		s.evict(oldestKey, ErrMemoryBudget, out)
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// removeBuffer 移除重组缓存并释放内存计数，调用方须持有 s.mu
+func (s *Session) removeBuffer(key bufferKey) *messageBuffer {
+	buf, exists := s.buffers[key]
+	if !exists {
+		return nil
+	}
+	delete(s.buffers, key)
+	s.buffered -= buf.size()
+	return buf
+}
+
+// dropBuffer 丢弃未完成的数据并记录上报，调用方须持有 s.mu
+func (s *Session) dropBuffer(key bufferKey, reason error, out *outbox) {
+	buf := s.removeBuffer(key)
+	if buf == nil || buf.evicted {
+		return
+	}
+	out.partials = append(out.partials, PartialMessage{
+		KvmID:   s.kvmID,
+		Channel: key.channel,
+		TaskID:  key.taskID,
+		Data:    buf.Data,
+		Reason:  reason,
+	})
+}
+
+// evict 因超时或超出限制淘汰未完成的数据，计入淘汰计数，并保留标记以丢弃该数据后续的数据包，调用方须持有 s.mu
+func (s *Session) evict(key bufferKey, reason error, out *outbox) {
+	buf, exists := s.buffers[key]
+	if !exists || buf.evicted {
+		return
+	}
+	atomic.AddUint64(&s.evicted, 1)
+	if reason != ErrIdleTimeout {
+		out.events = append(out.events, Event{Kind: EventOversize, Channel: key.channel, TaskID: key.taskID, SeqNum: buf.LastSeq, Err: reason})
+	}
+	s.dropBuffer(key, reason, out)
+	s.tombstone(key, buf.lastActive)
+}
+
+// tombstone 为无法完成的数据保留标记，丢弃其剩余的数据包，避免最后一片被当作单片数据交付；
+// 标记在收到最后一片、同一任务ID重新开始或空闲超时后清除，调用方须持有 s.mu
+func (s *Session) tombstone(key bufferKey, lastActive time.Time) {
+	s.buffers[key] = &messageBuffer{evicted: true, lastActive: lastActive}
+}
+
+// sweep 淘汰超过空闲时间未收到数据包的未完成数据
+func (s *Session) sweep(now time.Time) {
+	if s.idleTimeout <= 0 {
+		return
+	}
+	var out outbox
+	s.mu.Lock()
+	for key, buf := range s.buffers {
+		if now.Sub(buf.lastActive) <= s.idleTimeout {
+			continue
+		}
+		if buf.evicted {
+			delete(s.buffers, key)
+			continue
+		}
+		s.evict(key, ErrIdleTimeout, &out)
+	}
+	s.mu.Unlock()
+	s.dispatch(&out)
+}
+
+// sweepLoop 定期淘汰空闲的未完成数据，直到 done 关闭
+func (s *Session) sweepLoop(done <-chan struct{}) {
+	if s.idleTimeout <= 0 {
+		return
+	}
+	interval := s.idleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.sweep(now)
+		case <-done:
+			return
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestReassemblyMemoryBudget(t *testing.T) {
+	first := writeFrames(t, 1, payload(900))
+	second := writeFrames(t, 2, payload(900))
+	if len(first) != 3 || len(second) != 3 {
+		t.Fatalf("got %d and %d frames, want 3", len(first), len(second))
+	}
+
+	var messages []Message
+	var partials []PartialMessage
+	s := newTestSession(&messages, &partials)
+	s.memoryBudget = 1000
+	r := s.newSerialReader()
+	// 第二条数据的第二帧超出预算，淘汰最久未活动的第一条数据
+	for _, frame := range [][]byte{first[0], first[1], second[0], second[1], second[2], first[2]} {
+		r.process(frame)
+	}
+
+	if len(messages) != 1 || messages[0].TaskID != 2 {
+		t.Fatalf("got %d messages, want only task 2", len(messages))
+	}
+	if len(partials) != 1 || partials[0].TaskID != 1 || !errors.Is(partials[0].Reason, ErrMemoryBudget) {
+		t.Fatalf("got partials %+v, want task 1 evicted for memory budget", partials)
+	}
+	// 被淘汰数据的最后一片被标记丢弃，不会当作单片数据交付
+	stats := s.Stats()
+	if stats.Evicted != 1 || stats.BufferedBytes != 0 {
+		t.Errorf("got evicted %d, buffered %d", stats.Evicted, stats.BufferedBytes)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buffers) != 0 {
+		t.Errorf("%d buffers left", len(s.buffers))
+	}
+}
+
+func TestReassemblyMessageTooLarge(t *testing.T) {
+	data := payload(900)
+	frames := writeFrames(t, 3, payload(1500), data)
+
+	var messages []Message
+	var partials []PartialMessage
+	var events []Event
+	s := newTestSession(&messages, &partials)
+	s.maxMessageSize = 1000
+	s.onEvent = func(event Event) { events = append(events, event) }
+	s.newSerialReader().process(bytes.Join(frames, nil))
+
+	// 超长数据被淘汰，同一任务ID的下一条数据正常交付
+	if len(messages) != 1 || !bytes.Equal(messages[0].Data, data) {
+		t.Fatalf("got %d messages, want only the following message", len(messages))
+	}
+	if len(partials) != 1 || !errors.Is(partials[0].Reason, ErrMessageTooLarge) {
+		t.Fatalf("got partials %+v, want one too large", partials)
+	}
+	if len(events) != 1 || events[0].Kind != EventOversize || events[0].TaskID != 3 {
+		t.Errorf("got events %+v, want one Oversize", events)
+	}
+}
+
+func TestReassemblySweep(t *testing.T) {
+	idle := writeFrames(t, 1, payload(900))
+
+	var messages []Message
+	var partials []PartialMessage
+	s := newTestSession(&messages, &partials)
+	r := s.newSerialReader()
+	r.process(bytes.Join(idle[:2], nil))
+
+	now := time.Now()
+	s.sweep(now)
+	if len(partials) != 0 {
+		t.Fatalf("active buffer evicted: %+v", partials)
+	}
+
+	s.sweep(now.Add(2 * DefaultIdleTimeout))
+	if len(partials) != 1 || partials[0].TaskID != 1 || !errors.Is(partials[0].Reason, ErrIdleTimeout) || len(partials[0].Data) != 600 {
+		t.Fatalf("got partials %+v, want task 1 evicted for idle timeout", partials)
+	}
+	if stats := s.Stats(); stats.Evicted != 1 || stats.BufferedBytes != 0 {
+		t.Errorf("got evicted %d, buffered %d", stats.Evicted, stats.BufferedBytes)
+	}
+
+	// 超时后到达的最后一片被丢弃，标记空闲超时后清除
+	r.process(idle[2])
+	if len(messages) != 0 {
+		t.Fatalf("got %d messages after eviction", len(messages))
+	}
+	s.sweep(time.Now().Add(2 * DefaultIdleTimeout))
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.buffers) != 0 {
+		t.Errorf("%d buffers left after sweep", len(s.buffers))
+	}
+}
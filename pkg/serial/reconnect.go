@@ -0,0 +1,154 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/transport"
+	"go.uber.org/zap"
+)
+
+// ConnState 托管连接状态
+type ConnState int
+
+const (
+	StateConnecting   ConnState = iota + 1 // 正在建立连接
+	StateConnected                         // 连接已建立，开始监听
+	StateDisconnected                      // 连接断开或建立失败，等待重连
+)
+
+// String 连接状态名称
+func (s ConnState) String() string {
+	switch s {
+	case StateConnecting:
+		return "connecting"
+	case StateConnected:
+		return "connected"
+	case StateDisconnected:
+		return "disconnected"
+	default:
+		return fmt.Sprintf("ConnState(%d)", int(s))
+	}
+}
+
+// StateChange 托管连接的状态变化
+type StateChange struct {
+	KvmID   string    // 虚拟机ID
+	Channel Channel   // 监听通道
+	State   ConnState // 新状态
+	Attempt int       // 连续失败的次数：建立连接失败或连接未保持 Backoff.ResetAfter 即断开，稳定连接断开后清零
+	Err     error     // 断开或建立失败的原因
+}
+
+// ReconnectConfig 托管连接配置
+type ReconnectConfig struct {
+	Dial    transport.Dialer  // 建立连接，必填，通常由 transport.NewDialer 创建
+	Backoff transport.Backoff // 重连退避，零值使用默认配置
+	// OnConnect 连接建立后、开始监听前调用，用于切换写入器的连接、握手等；
+	// 返回错误时关闭该连接并按退避重连
+	OnConnect func(conn io.ReadWriteCloser) error
+	OnState   func(StateChange) // 状态变化回调，默认记录日志
+}
+
+// ServeReconnect 托管监听 channel 通道：连接断开后按指数退避与随机抖动重新建立连接并继续监听，
+// 直到 ctx 取消，返回 ctx 的错误。连接保持 Backoff.ResetAfter 以上后断开时退避重新从初始等待时间开始，
+// 更早断开（如对端接受连接后立即关闭）与建立连接失败一样继续增加等待时间；
+// 物理串口通道重连后会话恢复为握手前的状态，需要时在 OnConnect 中重新握手
+func (s *Session) ServeReconnect(ctx context.Context, channel Channel, config ReconnectConfig) error {
+	if config.Dial == nil {
+		return errors.New("托管连接未配置 Dial")
+	}
+	serve, err := s.serveFunc(channel)
+	if err != nil {
+		return err
+	}
+
+	attempt := 0
+	for {
+		s.changeState(config, StateChange{Channel: channel, State: StateConnecting, Attempt: attempt})
+		conn, err := s.connect(ctx, channel, config)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			attempt++
+			s.changeState(config, StateChange{Channel: channel, State: StateDisconnected, Attempt: attempt, Err: err})
+			if err = config.Backoff.Wait(ctx, attempt-1); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s.changeState(config, StateChange{Channel: channel, State: StateConnected, Attempt: attempt})
+		connected := config.Backoff.Now()
+		err = serve(ctx, conn)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if config.Backoff.Stable(config.Backoff.Now().Sub(connected)) {
+			attempt = 0
+		}
+		attempt++
+		s.changeState(config, StateChange{Channel: channel, State: StateDisconnected, Attempt: attempt, Err: err})
+		if err = config.Backoff.Wait(ctx, attempt-1); err != nil {
+			return err
+		}
+	}
+}
+
+// connect 建立连接并执行 OnConnect
+func (s *Session) connect(ctx context.Context, channel Channel, config ReconnectConfig) (io.ReadWriteCloser, error) {
+	conn, err := config.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if channel == ChannelSerial {
+		s.resetHandshake()
+	}
+	if config.OnConnect != nil {
+		if err = config.OnConnect(conn); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// serveFunc 通道对应的监听函数
+func (s *Session) serveFunc(channel Channel) (func(context.Context, io.ReadWriteCloser) error, error) {
+	switch channel {
+	case ChannelTask:
+		return s.ServeTask, nil
+	case ChannelCollect:
+		return s.ServeCollect, nil
+	case ChannelSerial:
+		return s.ServeSerial, nil
+	default:
+		return nil, fmt.Errorf("未知通道: %s", channel)
+	}
+}
+
+// changeState 上报托管连接的状态变化，未设置 OnState 时记录日志
+func (s *Session) changeState(config ReconnectConfig, change StateChange) {
+	change.KvmID = s.kvmID
+	if config.OnState != nil {
+		config.OnState(change)
+		return
+	}
+	fields := []zap.Field{
+		zap.Stringer("channel", change.Channel),
+		zap.Stringer("state", change.State),
+		zap.Int("attempt", change.Attempt),
+		zap.Error(change.Err),
+	}
+	switch change.State {
+	case StateConnected:
+		s.log.Info("连接已建立", fields...)
+	case StateDisconnected:
+		s.log.Warn("连接断开，等待重连", fields...)
+	default:
+		s.log.Debug("正在建立连接", fields...)
+	}
+}
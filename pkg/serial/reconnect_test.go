@@ -0,0 +1,106 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/transport"
+)
+
+// fakeClock 假时钟：Sleep 记录等待时间并推进时钟，等待 limit 次后取消 ctx
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+	limit  int
+	cancel context.CancelFunc
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	c.mu.Lock()
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	done := len(c.sleeps) >= c.limit
+	c.mu.Unlock()
+	if done {
+		c.cancel()
+	}
+	return ctx.Err()
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	c.mu.Unlock()
+}
+
+// uptimeConn 保持 uptime 后断开的连接：首次读取推进假时钟后返回 EOF
+type uptimeConn struct {
+	clock  *fakeClock
+	uptime time.Duration
+}
+
+func (c *uptimeConn) Read([]byte) (int, error) {
+	c.clock.advance(c.uptime)
+	return 0, io.EOF
+}
+
+func (c *uptimeConn) Write(p []byte) (int, error) { return len(p), nil }
+func (c *uptimeConn) Close() error                { return nil }
+
+func TestServeReconnectFlapping(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	clock := &fakeClock{limit: 6, cancel: cancel}
+	backoff := transport.Backoff{Initial: 10 * time.Millisecond, Max: 30 * time.Millisecond, Jitter: -1, ResetAfter: time.Second, Clock: clock}
+
+	// 依次为：立即断开、建立失败、立即断开、稳定连接后断开、立即断开、立即断开
+	errDial := errors.New("dial failed")
+	uptimes := []time.Duration{0, -1, 0, time.Second, 0, 0}
+	dials := 0
+	dial := func(context.Context) (io.ReadWriteCloser, error) {
+		uptime := uptimes[dials]
+		dials++
+		if uptime < 0 {
+			return nil, errDial
+		}
+		return &uptimeConn{clock: clock, uptime: uptime}, nil
+	}
+
+	var attempts []int
+	s := NewSession("flap", SessionConfig{OnEvent: func(Event) {}})
+	err := s.ServeReconnect(ctx, ChannelTask, ReconnectConfig{
+		Dial:    dial,
+		Backoff: backoff,
+		OnState: func(change StateChange) {
+			if change.State == StateDisconnected {
+				attempts = append(attempts, change.Attempt)
+			}
+		},
+	})
+	if err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+
+	// 未保持 ResetAfter 的连接与建立失败一样继续退避，稳定连接断开后从初始等待时间重新开始，最长 Max
+	wantAttempts := []int{1, 2, 3, 1, 2, 3}
+	if !reflect.DeepEqual(attempts, wantAttempts) {
+		t.Fatalf("got attempts %v, want %v", attempts, wantAttempts)
+	}
+	ms := time.Millisecond
+	wantSleeps := []time.Duration{10 * ms, 20 * ms, 30 * ms, 10 * ms, 20 * ms, 30 * ms}
+	if !reflect.DeepEqual(clock.sleeps, wantSleeps) {
+		t.Fatalf("got sleeps %v, want %v", clock.sleeps, wantSleeps)
+	}
+}
@@ -0,0 +1,296 @@
+package serial
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Agent 探针在注册表中的快照
+type Agent struct {
+	KvmID        string                         // 虚拟机ID
+	Conns        map[Channel]io.ReadWriteCloser // 各通道正在监听的连接
+	Version      byte                           // 握手协商的协议版本，未握手时为 0
+	Framing      Framing                        // ServeAuto 检测到的帧格式
+	RegisteredAt time.Time                      // 首次注册时间
+	LastSeen     time.Time                      // 最近一次收到数据的时间
+}
+
+// Channels 正在监听的通道，按通道顺序排列
+func (a Agent) Channels() []Channel {
+	channels := make([]Channel, 0, len(a.Conns))
+	for channel := range a.Conns {
+		channels = append(channels, channel)
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i] < channels[j] })
+	return channels
+}
+
+// AgentChangeKind 注册表变化类型
+type AgentChangeKind int
+
+const (
+	AgentRegistered AgentChangeKind = iota + 1 // 探针首次注册
+	AgentUpdated                               // 探针的通道连接、协议版本或帧格式变化
+	AgentRemoved                               // 探针所有通道断开或被移除
+)
+
+// String 变化类型名称
+func (k AgentChangeKind) String() string {
+	switch k {
+	case AgentRegistered:
+		return "registered"
+	case AgentUpdated:
+		return "updated"
+	case AgentRemoved:
+		return "removed"
+	default:
+		return fmt.Sprintf("AgentChangeKind(%d)", int(k))
+	}
+}
+
+// AgentChange 注册表变化通知，Agent 为变化后的快照，移除时为移除前的快照
+type AgentChange struct {
+	Seq   uint64 // 变化序号，按修改注册表的顺序从 1 递增
+	Kind  AgentChangeKind
+	Agent Agent
+}
+
+// AgentRegistry 探针注册表，由监听方（SessionConfig.Registry）维护，应用可并发查询；
+// 最近收到数据的时间只更新快照，不发送变化通知
+type AgentRegistry struct {
+	mu     sync.RWMutex
+	agents map[string]*Agent
+	seq    uint64 // 最近一次变化的序号，由 r.mu 保护
+
+	// 订阅者与待通知的变化，watchMutex 只在读写以下字段时短暂持有，不在回调期间持有
+	watchMutex sync.Mutex
+	watchers   map[int]func(AgentChange)
+	nextWatch  int
+	pending    []AgentChange // 按序号排列、尚未通知的变化
+	notifying  bool          // 已有 goroutine 在依次通知 pending 中的变化
+}
+
+// NewAgentRegistry 创建探针注册表
+func NewAgentRegistry() *AgentRegistry {
+	return &AgentRegistry{agents: make(map[string]*Agent), watchers: make(map[int]func(AgentChange))}
+}
+
+// Register 登记探针 channel 通道的连接，替换该通道之前的连接
+func (r *AgentRegistry) Register(kvmID string, channel Channel, conn io.ReadWriteCloser) Agent {
+	now := time.Now()
+	r.mu.Lock()
+	kind := AgentUpdated
+	agent, ok := r.agents[kvmID]
+	if !ok {
+		kind = AgentRegistered
+		agent = &Agent{KvmID: kvmID, Conns: make(map[Channel]io.ReadWriteCloser), RegisteredAt: now, LastSeen: now}
+		r.agents[kvmID] = agent
+	}
+	agent.Conns[channel] = conn
+	snapshot := agent.snapshot()
+	r.unlockAndNotify(AgentChange{Kind: kind, Agent: snapshot})
+	return snapshot
+}
+
+// Unregister 注销探针 channel 通道的连接，conn 与登记的连接不一致（已被重连替换）时忽略；
+// 探针所有通道注销后从注册表移除
+func (r *AgentRegistry) Unregister(kvmID string, channel Channel, conn io.ReadWriteCloser) {
+	r.mu.Lock()
+	agent, ok := r.agents[kvmID]
+	if !ok || !sameConn(agent.Conns[channel], conn) {
+		r.mu.Unlock()
+		return
+	}
+	delete(agent.Conns, channel)
+	kind := AgentUpdated
+	if len(agent.Conns) == 0 {
+		kind = AgentRemoved
+		delete(r.agents, kvmID)
+	}
+	snapshot := agent.snapshot()
+	r.unlockAndNotify(AgentChange{Kind: kind, Agent: snapshot})
+}
+
+// Move 将探针的连接从 from 通道改为登记在 to 通道下，替换 to 通道之前的连接；
+// ServeAuto 检测到 0xCAFE 协议时调用，conn 与 from 通道登记的连接不一致时忽略
+func (r *AgentRegistry) Move(kvmID string, from, to Channel, conn io.ReadWriteCloser) {
+	r.update(kvmID, func(agent *Agent) bool {
+		if from == to || !sameConn(agent.Conns[from], conn) {
+			return false
+		}
+		delete(agent.Conns, from)
+		agent.Conns[to] = conn
+		return true
+	})
+}
+
+// Remove 移除探针，不关闭其连接
+func (r *AgentRegistry) Remove(kvmID string) (Agent, bool) {
+	r.mu.Lock()
+	agent, ok := r.agents[kvmID]
+	if !ok {
+		r.mu.Unlock()
+		return Agent{}, false
+	}
+	delete(r.agents, kvmID)
+	snapshot := agent.snapshot()
+	r.unlockAndNotify(AgentChange{Kind: AgentRemoved, Agent: snapshot})
+	return snapshot, true
+}
+
+// Lookup 查询探针
+func (r *AgentRegistry) Lookup(kvmID string) (Agent, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	agent, ok := r.agents[kvmID]
+	if !ok {
+		return Agent{}, false
+	}
+	return agent.snapshot(), true
+}
+
+// Agents 所有探针，按虚拟机ID排序
+func (r *AgentRegistry) Agents() []Agent {
+	r.mu.RLock()
+	agents := make([]Agent, 0, len(r.agents))
+	for _, agent := range r.agents {
+		agents = append(agents, agent.snapshot())
+	}
+	r.mu.RUnlock()
+	sort.Slice(agents, func(i, j int) bool { return agents[i].KvmID < agents[j].KvmID })
+	return agents
+}
+
+// Len 探针数量
+func (r *AgentRegistry) Len() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.agents)
+}
+
+// Touch 记录探针最近一次收到数据的时间
+func (r *AgentRegistry) Touch(kvmID string) {
+	now := time.Now()
+	r.mu.Lock()
+	if agent, ok := r.agents[kvmID]; ok {
+		agent.LastSeen = now
+	}
+	r.mu.Unlock()
+}
+
+// SetVersion 记录探针握手协商的协议版本
+func (r *AgentRegistry) SetVersion(kvmID string, version byte) {
+	r.update(kvmID, func(agent *Agent) bool {
+		changed := agent.Version != version
+		agent.Version = version
+		return changed
+	})
+}
+
+// SetFraming 记录探针连接检测到的帧格式
+func (r *AgentRegistry) SetFraming(kvmID string, framing Framing) {
+	r.update(kvmID, func(agent *Agent) bool {
+		changed := agent.Framing != framing
+		agent.Framing = framing
+		return changed
+	})
+}
+
+// Watch 订阅注册表变化，回调按变化序号依次调用，同一时刻只有一个回调在执行；
+// 回调在修改注册表的 goroutine 中调用，并发修改时由正在通知的 goroutine 继续通知其他修改产生的变化。
+// 通知期间不持有任何锁，回调中可以查询、修改注册表或订阅，回调中产生的变化在当前通知结束后按序通知；
+// 返回取消订阅的函数，取消时正在通知的变化仍可能送达
+func (r *AgentRegistry) Watch(fn func(AgentChange)) (cancel func()) {
+	r.watchMutex.Lock()
+	defer r.watchMutex.Unlock()
+	id := r.nextWatch
+	r.nextWatch++
+	r.watchers[id] = fn
+	return func() {
+		r.watchMutex.Lock()
+		defer r.watchMutex.Unlock()
+		delete(r.watchers, id)
+	}
+}
+
+// update 修改已注册的探针，fn 返回 true 时发送变化通知
+func (r *AgentRegistry) update(kvmID string, fn func(agent *Agent) bool) {
+	r.mu.Lock()
+	agent, ok := r.agents[kvmID]
+	if !ok || !fn(agent) {
+		r.mu.Unlock()
+		return
+	}
+	snapshot := agent.snapshot()
+	r.unlockAndNotify(AgentChange{Kind: AgentUpdated, Agent: snapshot})
+}
+
+// unlockAndNotify 为变化分配序号并加入待通知队列，释放 r.mu 后通知订阅者，调用方须持有 r.mu；
+// 序号与入队都在 r.mu 内完成，队列顺序即修改注册表的顺序
+func (r *AgentRegistry) unlockAndNotify(change AgentChange) {
+	r.seq++
+	change.Seq = r.seq
+	r.watchMutex.Lock()
+	r.pending = append(r.pending, change)
+	r.watchMutex.Unlock()
+	r.mu.Unlock()
+	r.deliver()
+}
+
+// deliver 依次通知待通知的变化，已有 goroutine 在通知时直接返回，由其继续通知；
+// 调用回调时不持有任何锁
+func (r *AgentRegistry) deliver() {
+	r.watchMutex.Lock()
+	if r.notifying {
+		r.watchMutex.Unlock()
+		return
+	}
+	r.notifying = true
+	for len(r.pending) > 0 {
+		change := r.pending[0]
+		r.pending[0] = AgentChange{}
+		r.pending = r.pending[1:]
+		watchers := make([]func(AgentChange), 0, len(r.watchers))
+		for _, fn := range r.watchers {
+			watchers = append(watchers, fn)
+		}
+		r.watchMutex.Unlock()
+		for _, fn := range watchers {
+			fn(change)
+		}
+		r.watchMutex.Lock()
+	}
+	r.pending = nil
+	r.notifying = false
+	r.watchMutex.Unlock()
+}
+
+// snapshot 复制探针信息，调用方须持有 r.mu
+func (a *Agent) snapshot() Agent {
+	snapshot := *a
+	snapshot.Conns = make(map[Channel]io.ReadWriteCloser, len(a.Conns))
+	for channel, conn := range a.Conns {
+		snapshot.Conns[channel] = conn
+	}
+	return snapshot
+}
+
+// sameConn 判断两个连接是否为同一连接；不可比较的连接类型（如含切片的结构体值）直接比较会 panic，
+// 无法区分同类型的不同值，类型相同即视为同一连接
+func sameConn(a, b io.ReadWriteCloser) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	t := reflect.TypeOf(a)
+	if t != reflect.TypeOf(b) {
+		return false
+	}
+	if !t.Comparable() {
+		return true
+	}
+	return a == b
+}
@@ -0,0 +1,136 @@
+package serial
+
+import (
+	"net"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAgentRegistryNotifyOrder(t *testing.T) {
+	for run := 0; run < 50; run++ {
+		r := NewAgentRegistry()
+		conn, peer := net.Pipe()
+		r.Register("vm", ChannelSerial, conn)
+
+		var last byte
+		cancel := r.Watch(func(change AgentChange) {
+			runtime.Gosched()
+			last = change.Agent.Version
+		})
+		var wg sync.WaitGroup
+		for v := 1; v <= 32; v++ {
+			wg.Add(1)
+			go func(version byte) {
+				defer wg.Done()
+				r.SetVersion("vm", version)
+			}(byte(v))
+		}
+		wg.Wait()
+		cancel()
+
+		// 最后一次通知与注册表的最终状态一致
+		agent, _ := r.Lookup("vm")
+		if last != agent.Version {
+			t.Fatalf("last notification version %d, registry version %d", last, agent.Version)
+		}
+		_ = conn.Close()
+		_ = peer.Close()
+	}
+}
+
+func TestAgentRegistryWatchLookup(t *testing.T) {
+	r := NewAgentRegistry()
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+	r.Register("vm", ChannelSerial, conn)
+
+	// 回调中查询注册表，同时其他 goroutine 持续 Touch 与修改注册表
+	var seqs []uint64
+	cancel := r.Watch(func(change AgentChange) {
+		seqs = append(seqs, change.Seq)
+		r.Lookup("vm")
+		r.Touch("vm")
+		runtime.Gosched()
+		r.Lookup("vm")
+	})
+	defer cancel()
+
+	stop := make(chan struct{})
+	var touchers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		touchers.Add(1)
+		go func() {
+			defer touchers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					r.Touch("vm")
+					runtime.Gosched()
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		var wg sync.WaitGroup
+		for v := 1; v <= 64; v++ {
+			wg.Add(1)
+			go func(version byte) {
+				defer wg.Done()
+				r.SetVersion("vm", version)
+				r.Register("vm", Channel(version%3), conn)
+			}(byte(v))
+		}
+		wg.Wait()
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("watcher calling Lookup deadlocked with concurrent changes")
+	}
+	close(stop)
+	touchers.Wait()
+
+	// 变化按序号依次通知
+	for i := 1; i < len(seqs); i++ {
+		if seqs[i] != seqs[i-1]+1 {
+			t.Fatalf("notifications out of order: %v", seqs)
+		}
+	}
+}
+
+func TestAgentRegistryWatchModify(t *testing.T) {
+	r := NewAgentRegistry()
+	conn, peer := net.Pipe()
+	defer conn.Close()
+	defer peer.Close()
+
+	// 回调中修改注册表，产生的变化在当前通知结束后按序通知
+	var kinds []AgentChangeKind
+	cancel := r.Watch(func(change AgentChange) {
+		kinds = append(kinds, change.Kind)
+		if change.Kind == AgentRegistered {
+			r.SetVersion("vm", 2)
+			kinds = append(kinds, 0)
+		}
+	})
+	defer cancel()
+	r.Register("vm", ChannelSerial, conn)
+
+	want := []AgentChangeKind{AgentRegistered, 0, AgentUpdated}
+	if len(kinds) != len(want) {
+		t.Fatalf("got %v, want %v", kinds, want)
+	}
+	for i := range want {
+		if kinds[i] != want[i] {
+			t.Fatalf("got %v, want %v", kinds, want)
+		}
+	}
+}
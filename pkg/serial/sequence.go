@@ -0,0 +1,105 @@
+package serial
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+)
+
+// SequencePolicy 物理串口通道重复或超出重放窗口（protocol.ReplayWindow）数据包的处理策略
+type SequencePolicy int
+
+const (
+	SequenceAccept SequencePolicy = iota // 不检查序列号，与旧版行为一致（默认）
+	SequenceLog                          // 上报 EventReplayed 后照常处理
+	SequenceDrop                         // 上报 EventReplayed 并丢弃
+)
+
+// String 策略名称
+func (p SequencePolicy) String() string {
+	switch p {
+	case SequenceAccept:
+		return "accept"
+	case SequenceLog:
+		return "log"
+	case SequenceDrop:
+		return "drop"
+	default:
+		return fmt.Sprintf("SequencePolicy(%d)", int(p))
+	}
+}
+
+// checkSequence 按序列号窗口检查数据包，返回 false 表示丢弃。
+//
+// 配置加密时始终检查并丢弃，窗口与握手交换的随机数绑定：每次交换随机数派生新的帧密钥后重置，
+// 之前连接的帧无法通过认证，不依赖窗口拒绝；未加密的 Hello 不经过认证，不计入窗口。
+// 未配置加密时每次开始监听重置窗口，收到对端的 Hello 视为对端重新开始计数，同样重置窗口。
+// 可靠传输时对端重发的数据帧（配置加密时已通过认证）交给重组处理去重并重新发送 Ack，不视为重放
+func (s *Session) checkSequence(header protocol.PacketHeader) bool {
+	policy := s.sequencePolicy
+	if s.sealer != nil {
+		if isPlainHello(header) {
+			return true
+		}
+		policy = SequenceDrop
+	}
+	if policy == SequenceAccept {
+		return true
+	}
+
+	s.mu.Lock()
+	if s.sealer == nil && header.PacketType == protocol.PacketTypeHello {
+		s.replay.Reset()
+	}
+	err := s.replay.Check(header.SeqNum)
+	retransmitted := err != nil && s.reliable && s.retransmitted(header)
+	s.mu.Unlock()
+	if err == nil || retransmitted {
+		return true
+	}
+
+	atomic.AddUint64(&s.packetsReplayed, 1)
+	s.emit(securityEvent(header, err))
+	if policy == SequenceDrop {
+		atomic.AddUint64(&s.packetsDropped, 1)
+		return false
+	}
+	return true
+}
+
+// retransmitted 可靠传输时识别对端重发的数据帧：已交付数据的帧（对端未收到 Ack），
+// 或重组中数据首帧之后的帧（响应 Nack 或等待 Ack 超时后的重发），调用方须持有 s.mu
+func (s *Session) retransmitted(header protocol.PacketHeader) bool {
+	if protocol.IsControl(header.PacketType) {
+		return false
+	}
+	key := bufferKey{channel: ChannelSerial, taskID: int(header.TaskID)}
+	// 序列号按 uint32 回绕比较
+	if r, ok := s.delivered[key]; ok && int32(header.SeqNum-r.first) >= 0 && int32(header.SeqNum-r.last) <= 0 {
+		return true
+	}
+	buf, ok := s.buffers[key]
+	return ok && !buf.evicted && int32(header.SeqNum-buf.first) >= 0
+}
+
+// resetSequence 每次开始监听时调用，对端可能已重新开始计数：清除已交付数据的序列号范围，
+// 未配置加密时重置序列号窗口，配置加密时窗口在握手交换随机数后重置
+func (s *Session) resetSequence() {
+	s.mu.Lock()
+	s.delivered = make(map[bufferKey]seqRange)
+	if s.sealer == nil {
+		s.replay.Reset()
+	}
+	s.mu.Unlock()
+}
+
+// isPlainHello 是否为未加密的 Hello
+func isPlainHello(header protocol.PacketHeader) bool {
+	return header.PacketType == protocol.PacketTypeHello && header.Flags&protocol.FlagEncrypted == 0
+}
+
+// seqRange 一条数据首帧与最后一帧的序列号
+type seqRange struct {
+	first, last uint32
+}
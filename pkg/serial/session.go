@@ -0,0 +1,317 @@
+package serial
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+	"go.uber.org/zap"
+)
+
+// Channel 探针通信通道
+type Channel int
+
+const (
+	ChannelTask    Channel = iota // 任务通道（.fa）
+	ChannelCollect                // 数据采集通道（.fa2）
+	ChannelSerial                 // 物理串口通道（.fa00）
+)
+
+// String 通道对应的 socket 后缀
+func (c Channel) String() string {
+	switch c {
+	case ChannelTask:
+		return ".fa"
+	case ChannelCollect:
+		return ".fa2"
+	case ChannelSerial:
+		return ".fa00"
+	default:
+		return fmt.Sprintf("Channel(%d)", int(c))
+	}
+}
+
+// SessionConfig 会话配置
+type SessionConfig struct {
+	Logger    *zap.Logger          // 日志，默认不输出
+	Handler   ProcessMessageFunc   // 完整数据处理函数
+	OnDiscard func(PartialMessage) // 未完成数据被丢弃时回调，默认记录告警日志
+	OnEvent   func(Event)          // 监听异常事件回调（校验失败、序列号不连续、处理错误、断开等），默认记录日志
+	OnFraming func(Framing)        // ServeAuto 检测到帧格式或探针升级切换帧格式时回调
+
+	// Writer 同一物理串口连接的写入器，用于发送 Ack/Nack 并响应对端的重传请求
+	Writer *Writer
+	// Sealer 设置后只接受使用相同预共享密钥加密认证的帧，未加密、认证失败与重放的帧被丢弃并上报安全事件
+	Sealer *protocol.Sealer
+	// SequencePolicy 重复或超出重放窗口数据包的处理策略，默认 SequenceAccept；配置 Sealer 时固定为 SequenceDrop
+	SequencePolicy SequencePolicy
+	// MaxFrameSize 物理串口通道单帧最大数据长度（含加密开销），头部长度超过该值的帧视为损坏并立即重新定位下一帧，
+	// 不等待缓存声明的长度；默认 DefaultMaxFrameSize，负数表示只受协议版本上限限制，对端的 MaxPayload 须不超过该值
+	MaxFrameSize int
+	// Reliable 启用可靠传输：缓存乱序到达的数据包并对缺失的帧发送 Nack，数据完整后发送 Ack，需同时设置 Writer
+	Reliable bool
+
+	// 重组缓存限制，零值使用默认值，负数表示不限制；被淘汰的数据通过 OnDiscard 上报并计入 SessionStats.Evicted
+	IdleTimeout    time.Duration // 未完成数据最长空闲时间，默认 DefaultIdleTimeout
+	MaxMessageSize int           // 单条重组数据最大字节数，默认 DefaultMaxMessageSize
+	MemoryBudget   int           // 会话所有未完成数据最大占用字节数，超出时淘汰最久未活动的数据，默认 DefaultMemoryBudget
+
+	// Registry 设置后监听开始时登记通道连接、断开时注销，并记录最近收到数据的时间、协商的协议版本与帧格式
+	Registry *AgentRegistry
+}
+
+// PartialMessage 未能重组完成而被丢弃的数据
+type PartialMessage struct {
+	KvmID   string  // 虚拟机ID
+	Channel Channel // 接收通道
+	TaskID  int     // 任务ID
+	Data    []byte  // 已接收的数据
+	Reason  error   // 丢弃原因
+}
+
+// ListenError 监听停止原因
+type ListenError struct {
+	KvmID   string  // 虚拟机ID
+	Channel Channel // 监听通道
+	Pending int     // 停止时丢弃的未完成数据数
+	Err     error   // ctx 取消原因或连接读取错误
+}
+
+func (e *ListenError) Error() string {
+	return fmt.Sprintf("agent[%s]%s 监听停止（丢弃%d条未完成数据）: %v", e.KvmID, e.Channel, e.Pending, e.Err)
+}
+
+func (e *ListenError) Unwrap() error {
+	return e.Err
+}
+
+// SessionStats 会话计数
+type SessionStats struct {
+	PacketsReceived uint64 // 接收的有效数据包数
+	PacketsDropped  uint64 // 丢弃的数据包数（格式错误、校验失败、序列号不连续等）
+	MessagesHandled uint64 // 交付处理的完整数据数
+	HandlerErrors   uint64 // 处理函数返回错误的次数
+	Evicted         uint64 // 因超时或超出限制被淘汰的未完成数据数
+	PacketsReplayed uint64 // 重复或超出重放窗口的数据包数，按 SequencePolicy 处理
+	BufferedBytes   int    // 当前未完成数据占用的字节数
+}
+
+// Session 单个探针（kvmID）的通信会话，持有各通道独立的重组缓存、日志与计数，
+// 多个探针在同一进程内使用各自的会话互不影响
+type Session struct {
+	kvmID     string
+	log       *zap.Logger
+	handler   ProcessMessageFunc
+	onDiscard func(PartialMessage)
+	onEvent   func(Event)
+	onFraming func(Framing)
+	registry  *AgentRegistry
+	writer    *Writer
+	reliable  bool
+	sealer    *protocol.Sealer
+
+	sequencePolicy SequencePolicy
+
+	maxFrameSize   int
+	idleTimeout    time.Duration
+	maxMessageSize int
+	memoryBudget   int
+
+	// 各通道按任务ID缓存的未完成数据：任务通道（.fa）、数据采集通道（.fa2）、物理串口通道（.fa00）
+	mu       sync.Mutex
+	buffers  map[bufferKey]*messageBuffer
+	buffered int // 未完成数据占用的字节数
+
+	replay protocol.ReplayWindow // 物理串口通道的序列号窗口，由 s.mu 保护
+
+	delivered map[bufferKey]seqRange // 可靠传输时各任务最近交付数据的序列号范围，用于忽略 Ack 丢失后的重发，由 s.mu 保护
+
+	// ServeAuto 检测到的帧格式
+	framingMutex sync.Mutex
+	framing      Framing
+
+	// 握手协商结果
+	handshakeMutex sync.Mutex
+	negotiated     *Negotiated
+
+	packetsReceived uint64
+	packetsDropped  uint64
+	messagesHandled uint64
+	handlerErrors   uint64
+	evicted         uint64
+	packetsReplayed uint64
+}
+
+// NewSession 创建探针通信会话
+func NewSession(kvmID string, config SessionConfig) *Session {
+	log := config.Logger
+	if log == nil {
+		log = zap.NewNop()
+	}
+	return &Session{
+		kvmID:          kvmID,
+		log:            log.With(zap.String("kvmID", kvmID)),
+		handler:        config.Handler,
+		onDiscard:      config.OnDiscard,
+		onEvent:        config.OnEvent,
+		onFraming:      config.OnFraming,
+		registry:       config.Registry,
+		writer:         config.Writer,
+		reliable:       config.Reliable && config.Writer != nil,
+		sealer:         config.Sealer,
+		sequencePolicy: config.SequencePolicy,
+		maxFrameSize:   intOrDefault(config.MaxFrameSize, DefaultMaxFrameSize),
+		idleTimeout:    durationOrDefault(config.IdleTimeout, DefaultIdleTimeout),
+		maxMessageSize: intOrDefault(config.MaxMessageSize, DefaultMaxMessageSize),
+		memoryBudget:   intOrDefault(config.MemoryBudget, DefaultMemoryBudget),
+		buffers:        make(map[bufferKey]*messageBuffer),
+		delivered:      make(map[bufferKey]seqRange),
+	}
+}
+
+// durationOrDefault 零值使用默认值
+func durationOrDefault(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// intOrDefault 零值使用默认值
+func intOrDefault(n, def int) int {
+	if n == 0 {
+		return def
+	}
+	return n
+}
+
+// KvmID 会话对应的虚拟机ID
+func (s *Session) KvmID() string {
+	return s.kvmID
+}
+
+// Stats 获取会话计数
+func (s *Session) Stats() SessionStats {
+	return SessionStats{
+		PacketsReceived: atomic.LoadUint64(&s.packetsReceived),
+		PacketsDropped:  atomic.LoadUint64(&s.packetsDropped),
+		MessagesHandled: atomic.LoadUint64(&s.messagesHandled),
+		HandlerErrors:   atomic.LoadUint64(&s.handlerErrors),
+		Evicted:         atomic.LoadUint64(&s.evicted),
+		PacketsReplayed: atomic.LoadUint64(&s.packetsReplayed),
+		BufferedBytes:   s.bufferedBytes(),
+	}
+}
+
+// bufferedBytes 当前未完成数据占用的字节数
+func (s *Session) bufferedBytes() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buffered
+}
+
+// handle 将完整数据交给处理函数，处理函数返回错误时上报 EventHandlerError
+func (s *Session) handle(msg Message) {
+	atomic.AddUint64(&s.messagesHandled, 1)
+	if s.handler == nil {
+		return
+	}
+	if err := s.handler(msg); err != nil {
+		atomic.AddUint64(&s.handlerErrors, 1)
+		s.emit(Event{Kind: EventHandlerError, Channel: msg.Channel, TaskID: msg.TaskID, SeqNum: msg.SeqNum, Err: err})
+	}
+}
+
+// serve 循环读取连接数据交给 process 处理，直到连接断开或 ctx 取消；
+// 退出时关闭连接，并上报该通道所有未完成的数据
+func (s *Session) serve(ctx context.Context, c *servedConn, process func(buf []byte)) error {
+	conn := c.conn
+	defer conn.Close()
+	if s.registry != nil {
+		s.registry.Register(s.kvmID, c.channel, conn)
+		defer func() { s.registry.Unregister(s.kvmID, c.channel, conn) }()
+	}
+
+	// ctx 取消时关闭连接，使阻塞的 Read 立即返回
+	done := make(chan struct{})
+	defer close(done)
+	go s.sweepLoop(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		buf := make([]byte, BufSize)
+		n, err := conn.Read(buf)
+		if n > 0 {
+			if s.registry != nil {
+				s.registry.Touch(s.kvmID)
+			}
+			process(buf[:n])
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				err = ctxErr
+			}
+			s.emit(Event{Kind: EventDisconnect, Channel: c.channel, Err: err})
+			return &ListenError{
+				KvmID:   s.kvmID,
+				Channel: c.channel,
+				Pending: s.flush(c.channel, err),
+				Err:     err,
+			}
+		}
+	}
+}
+
+// servedConn 监听中的连接及其所属通道，ServeAuto 检测到 0xCAFE 协议后通道切换为 ChannelSerial
+type servedConn struct {
+	conn    io.ReadWriteCloser
+	channel Channel
+}
+
+// switchChannel 切换连接所属的通道，注册表改为登记在新通道下，只能在监听连接的 goroutine 中调用
+func (s *Session) switchChannel(c *servedConn, channel Channel) {
+	if c.channel == channel {
+		return
+	}
+	if s.registry != nil {
+		s.registry.Move(s.kvmID, c.channel, channel, c.conn)
+	}
+	c.channel = channel
+}
+
+// flush 清理通道所有未完成的数据并逐条上报，返回清理的数量
+func (s *Session) flush(channel Channel, reason error) int {
+	var out outbox
+	s.mu.Lock()
+	for key := range s.buffers {
+		if key.channel == channel {
+			s.dropBuffer(key, reason, &out)
+		}
+	}
+	s.mu.Unlock()
+	s.dispatch(&out)
+	return len(out.partials)
+}
+
+// discard 上报被丢弃的未完成数据
+func (s *Session) discard(partial PartialMessage) {
+	if s.onDiscard != nil {
+		s.onDiscard(partial)
+		return
+	}
+	s.log.Warn("丢弃未完成数据",
+		zap.Stringer("channel", partial.Channel),
+		zap.Int("taskID", partial.TaskID),
+		zap.Int("size", len(partial.Data)),
+		zap.NamedError("reason", partial.Reason))
+}
@@ -1,101 +1,539 @@
 package serial
 
 import (
-	"encoding/binary"
 	"errors"
+	"fmt"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
 	"github.com/xuchao-ovo/agent-sdk-go/pkg/utils"
-	"hash/crc32"
-	"log"
+	"io"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-var mu sync.Mutex
-var seqNum uint32 // 新增序列号计数器
+// PacketHeader 数据包头部，定义见 protocol 包
+type PacketHeader = protocol.PacketHeader
 
-type PacketHeader struct {
-	MagicNumber uint16
-	Version     byte
-	SeqNum      uint32
-	PacketType  byte
-	Status      byte
-	TaskID      byte
-	DataLen     uint16
+// Writer 单个连接的数据写入器，持有独立的序列号计数器与写锁，
+// 同一连接的写入串行进行，不同连接的写入互不阻塞
+type Writer struct {
+	conn   io.Writer
+	pool   *utils.TaskIDPool
+	config WriterConfig
+
+	mu      sync.Mutex
+	seqNum  uint32  // 下一个数据包的序列号，按 uint32 回绕
+	wrapped bool    // 序列号已回绕，加密时重新握手前不能再发送（nonce 会重复）
+	version byte    // 数据帧使用的协议版本，握手协商前为 ProtocolVersion
+	caps    uint32  // 握手协商的共同能力位
+	shared  *uint32 // 不为 nil 时每条数据从共用计数器预留序列号，供包级写入函数使用
+
+	// 连接的写入独立加锁，重传可以插在正在进行的分片写入的帧之间；同时持有时先取 mu
+	connMu sync.Mutex
+
+	// 重传窗口独立加锁，处理 Ack/Nack 时不必等待正在进行的分片写入；同时持有时先取 mu，不与 connMu 同时持有
+	windowMu sync.Mutex
+	window   map[int][]sentPacket // 按任务ID保留的当前数据已发送的帧，用于重传
+	awaiting map[int]*ackWait     // 按任务ID等待接收方 Ack 的数据
 }
 
-func Write(con net.Conn, writeType int, byteData []byte, pool utils.TaskIDPool) error {
-	mu.Lock()
-	defer mu.Unlock()
+// ackWait 等待 Ack 的数据，超时后重发重传窗口中的帧
+type ackWait struct {
+	seqNum   uint32 // 最后一帧的序列号
+	attempts int    // 已重发次数
+	timer    *time.Timer
+}
 
-	const dataSize = 300
-	dataLen := len(byteData)
+// sentPacket 已发送的数据帧
+type sentPacket struct {
+	seqNum uint32
+	packet []byte
+}
 
-	taskID, err := pool.GetTaskID()
+// ErrRetransmitUnavailable 请求重传的数据帧已不在重传窗口中
+var ErrRetransmitUnavailable = errors.New("请求重传的数据包不在重传窗口中")
+
+// ErrSequenceExhausted 加密时序列号已用尽，继续发送会重复使用 nonce，须重新握手派生新的帧密钥
+var ErrSequenceExhausted = errors.New("序列号已用尽，需重新握手")
+
+// NewWriter 创建连接的数据写入器，pool 为 nil 时只能使用 WriteTask 写入
+func NewWriter(conn io.Writer, pool *utils.TaskIDPool, config WriterConfig) (*Writer, error) {
+	config, err := config.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{conn: conn, pool: pool, config: config, version: ProtocolVersion}, nil
+}
+
+// Write 从任务ID池获取任务ID并分片写入数据，写入完成后回收任务ID
+func (w *Writer) Write(writeType int, byteData []byte) error {
+	if w.pool == nil {
+		return errors.New("写入器未配置任务ID池")
+	}
+	taskID, err := w.pool.GetTaskID()
 	if err != nil {
 		return err
 	}
+	defer w.pool.RecycleTaskID(taskID)
+	return w.WriteTask(writeType, taskID, byteData)
+}
+
+// WriteTask 使用指定的任务ID分片写入数据，调用方负责任务ID的分配与回收，
+// 探针应答时会携带相同的任务ID，用于请求与应答的关联
+func (w *Writer) WriteTask(writeType int, taskID int, byteData []byte) error {
+	return w.writeTask(writeType, taskID, byteData, w.config)
+}
+
+// SeqNum 下一个数据包的序列号
+func (w *Writer) SeqNum() uint32 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.seqNum
+}
+
+// Version 数据帧使用的协议版本
+func (w *Writer) Version() byte {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.version
+}
+
+// SetVersion 设置数据帧使用的协议版本，通常由握手协商结果决定
+func (w *Writer) SetVersion(version byte) error {
+	if !protocol.IsSupportedVersion(version) {
+		return protocol.ErrBadVersion
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.version = version
+	return nil
+}
+
+// SetNegotiated 应用握手协商结果：数据帧使用的协议版本与双方共同的能力位
+func (w *Writer) SetNegotiated(version byte, capabilities uint32) error {
+	if !protocol.IsSupportedVersion(version) {
+		return protocol.ErrBadVersion
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.version = version
+	w.caps = capabilities
+	return nil
+}
+
+// SetConn 重连后切换写入的连接：协议版本与能力位恢复为握手前的状态，清空重传窗口；
+// 序列号继续递增，避免加密时重复使用 nonce
+func (w *Writer) SetConn(conn io.Writer) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.connMu.Lock()
+	w.conn = conn
+	w.connMu.Unlock()
+	w.version = ProtocolVersion
+	w.caps = 0
+	w.windowMu.Lock()
+	for _, wait := range w.awaiting {
+		wait.timer.Stop()
+	}
+	w.window = nil
+	w.awaiting = nil
+	w.windowMu.Unlock()
+}
+
+// Hello 本端的握手数据：支持的协议版本与 gzip 解压，配置重传窗口时声明可靠传输能力
+func (w *Writer) Hello() protocol.Hello {
+	hello := protocol.Hello{Versions: protocol.SupportedVersions, Capabilities: protocol.CapGzip}
+	if w.config.RetransmitWindow > 0 {
+		hello.Capabilities |= protocol.CapReliable
+	}
+	return hello
+}
+
+// WriteHello 发送握手数据包，reply 表示应答对端的 Hello；握手数据包始终使用 v1 头部且不加密，
+// 配置加密时携带本端随机数（用于派生帧密钥）并以预共享密钥签名
+func (w *Writer) WriteHello(reply bool) error {
+	hello := w.Hello()
+	hello.Reply = reply
+	if w.config.Sealer != nil {
+		var err error
+		if hello, err = w.config.Sealer.SignHello(hello); err != nil {
+			return err
+		}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.config.Sealer != nil {
+		// 握手交换新的随机数后使用新的帧密钥，序列号可继续使用
+		w.wrapped = false
+	}
+	return w.writeControl(ProtocolVersion, protocol.PacketTypeHello, 0, protocol.EncodeHello(hello))
+}
+
+// writeTask 按写入配置分片写入数据，config 须已填充默认值
+func (w *Writer) writeTask(writeType int, taskID int, byteData []byte, config WriterConfig) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	version := w.frameVersion(config)
+	if taskID < 0 || taskID > protocol.MaxTaskIDOf(version) {
+		return fmt.Errorf("%w（任务ID: %d，协议版本: %d）", protocol.ErrTaskIDTooBig, taskID, version)
+	}
+	dataSize := config.MaxPayload
+	limit := protocol.MaxDataLenOf(version)
+	if config.Sealer != nil {
+		limit -= config.Sealer.Overhead()
+	}
+	if dataSize > limit {
+		dataSize = limit
+	}
+	byteData, flags := w.compress(byteData, version, config)
+	dataLen := len(byteData)
+
+	// 空数据也发送一帧，保证接收方能收到这条数据
+	frameCount := (dataLen + dataSize - 1) / dataSize
+	if frameCount == 0 {
+		frameCount = 1
+	}
+	if w.shared != nil {
+		w.seqNum = atomic.AddUint32(w.shared, uint32(frameCount)) - uint32(frameCount)
+	}
 
-	for offset := 0; offset < dataLen; offset += dataSize {
+	for i := 0; i < frameCount; i++ {
 		// 准备数据块
+		offset := i * dataSize
 		end := offset + dataSize
 		if end > dataLen {
 			end = dataLen
 		}
 		chunk := byteData[offset:end]
+		status := frameStatus(i, frameCount)
 
-		// 确定数据状态
-		var status byte
-		if offset == 0 && dataLen > dataSize {
-			status = 0 // DataStart
-		} else if offset+dataSize >= dataLen {
-			status = 2 // DataEnd
-		} else {
-			status = 1 // DataTransfer
+		if config.Sealer != nil && w.wrapped {
+			return ErrSequenceExhausted
+		}
+		// 编码数据包（header + 数据 + CRC32 4 字节）
+		packet, err := encodeFrame(config.Sealer, protocol.DataPacket{
+			Header: PacketHeader{
+				MagicNumber: MagicNumber,
+				Version:     version,
+				SeqNum:      w.seqNum,
+				PacketType:  byte(writeType),
+				Status:      byte(status),
+				Flags:       flags,
+				TaskID:      uint16(taskID),
+			},
+			Data: chunk,
+		})
+		if err != nil {
+			return err
 		}
 
-		// 创建 header
-		header := PacketHeader{
-			MagicNumber: MagicNumber,
-			Version:     ProtocolVersion,
-			SeqNum:      seqNum,
-			PacketType:  byte(writeType),
-			Status:      status,
-			TaskID:      byte(taskID),
-			DataLen:     uint16(len(chunk)),
+		// 写入串口
+		if err = w.writeFrame(packet, config); err != nil {
+			return fmt.Errorf("写入数据失败（任务ID: %d）: %w", taskID, err)
 		}
 
-		// 准备完整数据包（header 12 字节 + 数据 + CRC32 4 字节）
-		packet := make([]byte, 12+len(chunk)+4)
+		// 只有对端在握手中声明支持可靠传输时才会回复 Ack/Nack
+		if config.RetransmitWindow > 0 && w.caps&protocol.CapReliable != 0 {
+			w.remember(taskID, i == 0, sentPacket{seqNum: w.seqNum, packet: packet})
+			if i == frameCount-1 {
+				w.expectAck(taskID, w.seqNum, config)
+			}
+		}
+		w.advance() // 增加序列号
+	}
 
-		// 写入 header
-		binary.BigEndian.PutUint16(packet[0:], header.MagicNumber)
-		packet[2] = header.Version
-		binary.BigEndian.PutUint32(packet[3:], header.SeqNum)
-		packet[7] = header.PacketType
-		packet[8] = header.Status
-		packet[9] = header.TaskID
-		binary.BigEndian.PutUint16(packet[10:], header.DataLen)
+	return nil
+}
 
-		// 写入数据
-		copy(packet[12:], chunk)
+// frameVersion 数据帧使用的协议版本，加密帧固定使用 v2，调用方须持有 w.mu
+func (w *Writer) frameVersion(config WriterConfig) byte {
+	if config.Sealer != nil {
+		return protocol.ProtocolVersion2
+	}
+	return w.version
+}
 
-		// 写入 CRC32 校验码
-		crc := crc32.ChecksumIEEE(packet[:12+len(chunk)])
-		binary.BigEndian.PutUint32(packet[12+len(chunk):], crc)
+// encodeFrame 编码数据帧，sealer 不为 nil 时加密认证
+func encodeFrame(sealer *protocol.Sealer, packet protocol.DataPacket) ([]byte, error) {
+	if sealer != nil {
+		return sealer.Seal(packet)
+	}
+	return packet.MarshalBinary()
+}
 
-		// 写入串口
-		_, err = con.Write(packet)
+// compress 协商 v2 协议且对端支持 gzip 时压缩达到阈值的数据，返回发送的数据与头部标志位，调用方须持有 w.mu
+func (w *Writer) compress(data []byte, version byte, config WriterConfig) ([]byte, byte) {
+	if version < protocol.ProtocolVersion2 || w.caps&protocol.CapGzip == 0 ||
+		config.CompressThreshold < 0 || len(data) < config.CompressThreshold {
+		return data, 0
+	}
+	compressed, err := protocol.Compress(data)
+	if err != nil || len(compressed) >= len(data) {
+		return data, 0
+	}
+	return compressed, protocol.FlagCompressed
+}
+
+// writeFrame 按限速与帧间节奏写入一帧，连接的写锁只在写入期间持有
+func (w *Writer) writeFrame(packet []byte, config WriterConfig) error {
+	config.Limiter.Wait(len(packet))
+	w.connMu.Lock()
+	_, err := w.conn.Write(packet)
+	w.connMu.Unlock()
+	if err != nil {
+		return err
+	}
+	config.Pacer.Pace(len(packet))
+	return nil
+}
+
+// remember 将已发送帧加入任务的重传窗口，窗口保留当前数据的全部帧直到收到 Ack，
+// 接收方可以用 Nack 请求其中任意一帧，调用方须持有 w.mu
+func (w *Writer) remember(taskID int, first bool, sent sentPacket) {
+	w.windowMu.Lock()
+	defer w.windowMu.Unlock()
+	if w.window == nil {
+		w.window = make(map[int][]sentPacket)
+	}
+	// 新的一条数据开始，之前未确认的帧不会再被请求
+	if first {
+		w.window[taskID] = w.window[taskID][:0]
+		if wait, ok := w.awaiting[taskID]; ok {
+			wait.timer.Stop()
+			delete(w.awaiting, taskID)
+		}
+	}
+	w.window[taskID] = append(w.window[taskID], sent)
+}
+
+// expectAck 数据的最后一帧已发送，等待接收方 Ack，超时后重发，MaxRetransmits 为负数时不等待，调用方须持有 w.mu
+func (w *Writer) expectAck(taskID int, seqNum uint32, config WriterConfig) {
+	if config.MaxRetransmits < 0 {
+		return
+	}
+	w.windowMu.Lock()
+	defer w.windowMu.Unlock()
+	if w.awaiting == nil {
+		w.awaiting = make(map[int]*ackWait)
+	}
+	wait := &ackWait{seqNum: seqNum}
+	wait.timer = time.AfterFunc(config.AckTimeout, func() { w.ackTimeout(taskID, wait, config) })
+	w.awaiting[taskID] = wait
+}
+
+// ackTimeout 等待 Ack 超时，重发数据最后 RetransmitWindow 帧：接收方缺少最后几帧时补齐，
+// 缺少中间的帧时由接收方收到重发的最后一帧后再次 Nack，整条数据不超过 RetransmitWindow 帧时也能补齐丢失的首帧，
+// 接收方忽略已收到的帧；超过最大重发次数后放弃并释放窗口
+func (w *Writer) ackTimeout(taskID int, wait *ackWait, config WriterConfig) {
+	w.windowMu.Lock()
+	if w.awaiting[taskID] != wait {
+		// 已确认或同一任务ID已开始新的数据
+		w.windowMu.Unlock()
+		return
+	}
+	if wait.attempts >= config.MaxRetransmits {
+		delete(w.awaiting, taskID)
+		delete(w.window, taskID)
+		w.windowMu.Unlock()
+		return
+	}
+	wait.attempts++
+	frames := w.window[taskID]
+	if len(frames) > config.RetransmitWindow {
+		frames = frames[len(frames)-config.RetransmitWindow:]
+	}
+	frames = append([]sentPacket(nil), frames...)
+	w.windowMu.Unlock()
+
+	for _, sent := range frames {
+		if err := w.resend(sent); err != nil {
+			break
+		}
+	}
+
+	w.windowMu.Lock()
+	if w.awaiting[taskID] == wait {
+		wait.timer.Reset(config.AckTimeout)
+	}
+	w.windowMu.Unlock()
+}
+
+// resend 按原序列号重发已发送的帧，不等待正在进行的分片写入
+func (w *Writer) resend(sent sentPacket) error {
+	return w.writeFrame(sent.packet, w.config)
+}
+
+// release 接收方已确认 seqNum 及之前的帧，从任务的重传窗口中移除，调用方须持有 w.windowMu
+func (w *Writer) release(taskID int, seqNum uint32) {
+	if wait, ok := w.awaiting[taskID]; ok && int32(wait.seqNum-seqNum) <= 0 {
+		wait.timer.Stop()
+		delete(w.awaiting, taskID)
+	}
+	frames := w.window[taskID]
+	kept := frames[:0]
+	for _, sent := range frames {
+		// 序列号按 uint32 回绕比较
+		if int32(sent.seqNum-seqNum) > 0 {
+			kept = append(kept, sent)
+		}
+	}
+	if len(kept) == 0 {
+		delete(w.window, taskID)
+		return
+	}
+	w.window[taskID] = kept
+}
+
+// HandleControl 处理接收方返回的控制数据包：Ack 释放任务重传窗口中已确认的帧，Nack 重传缺失的帧，
+// 部分帧已不在窗口中时返回 ErrRetransmitUnavailable。重传不等待正在进行的分片写入，
+// 但会受限速与帧间节奏影响，读取连接的 goroutine 不应直接调用
+func (w *Writer) HandleControl(packet *protocol.DataPacket) error {
+	taskID := int(packet.Header.TaskID)
+	switch packet.Header.PacketType {
+	case protocol.PacketTypeAck:
+		seqNum, err := protocol.DecodeAck(packet.Data)
+		if err != nil {
+			return err
+		}
+		w.windowMu.Lock()
+		w.release(taskID, seqNum)
+		w.windowMu.Unlock()
+		return nil
+	case protocol.PacketTypeNack:
+		seqs, err := protocol.DecodeNack(packet.Data)
 		if err != nil {
-			log.Println("Error writing to serial port, taskID:", taskID, "error:", err)
-			pool.RecycleTaskID(taskID)
-			return errors.New("写入数据失败")
+			return err
+		}
+		var missing int
+		for _, seq := range seqs {
+			sent, ok := w.find(taskID, seq)
+			if !ok {
+				missing++
+				continue
+			}
+			if err = w.resend(sent); err != nil {
+				return err
+			}
+		}
+		if missing > 0 {
+			return fmt.Errorf("%w（任务ID: %d，缺失%d帧）", ErrRetransmitUnavailable, taskID, missing)
 		}
-		time.Sleep(20 * time.Millisecond)
+		return nil
+	default:
+		return protocol.ErrBadControl
+	}
+}
 
-		seqNum++ // 增加序列号
+// find 在任务的重传窗口中查找序列号对应的帧
+func (w *Writer) find(taskID int, seqNum uint32) (sentPacket, bool) {
+	w.windowMu.Lock()
+	defer w.windowMu.Unlock()
+	for _, sent := range w.window[taskID] {
+		if sent.seqNum == seqNum {
+			return sent, true
+		}
 	}
+	return sentPacket{}, false
+}
 
-	pool.RecycleTaskID(taskID)
+// WriteControl 发送单帧控制数据包（Ack、Nack），使用写入器自身的序列号
+func (w *Writer) WriteControl(packetType byte, taskID int, data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.writeControl(w.frameVersion(w.config), packetType, taskID, data)
+}
+
+// writeControl 按指定协议版本发送单帧控制数据包，配置加密时除 Hello 外加密认证，调用方须持有 w.mu
+func (w *Writer) writeControl(version byte, packetType byte, taskID int, data []byte) error {
+	sealer := w.config.Sealer
+	if packetType == protocol.PacketTypeHello {
+		sealer = nil
+	}
+	if sealer != nil && w.wrapped {
+		return ErrSequenceExhausted
+	}
+	packet, err := encodeFrame(sealer, protocol.DataPacket{
+		Header: PacketHeader{
+			MagicNumber: MagicNumber,
+			Version:     version,
+			SeqNum:      w.seqNum,
+			PacketType:  packetType,
+			Status:      byte(protocol.DataEnd),
+			TaskID:      uint16(taskID),
+		},
+		Data: data,
+	})
+	if err != nil {
+		return err
+	}
+	w.config.Limiter.Wait(len(packet))
+	w.connMu.Lock()
+	_, err = w.conn.Write(packet)
+	w.connMu.Unlock()
+	if err != nil {
+		return err
+	}
+	w.advance()
 	return nil
 }
+
+// advance 增加序列号并记录回绕，调用方须持有 w.mu
+func (w *Writer) advance() {
+	w.seqNum++
+	if w.seqNum == 0 {
+		w.wrapped = true
+	}
+}
+
+// frameStatus 计算第 index 帧（共 count 帧）的数据状态，单帧数据只发送 DataEnd
+func frameStatus(index, count int) int {
+	switch {
+	case index == count-1:
+		return protocol.DataEnd
+	case index == 0:
+		return protocol.DataStart
+	default:
+		return protocol.DataTransfer
+	}
+}
+
+// packageSeq 包级写入函数共用的序列号计数器，每条数据整体预留连续的序列号，
+// 不同连接的写入互不阻塞，同一连接上各条数据的序列号保持递增
+var packageSeq uint32
+
+// ReleaseWriter 原用于释放包级写入函数为连接保留的写入器；包级写入函数已不再按连接保留状态，
+// 调用无任何效果，保留以兼容旧代码
+func ReleaseWriter(con io.Writer) {}
+
+// Write 从任务ID池获取任务ID并分片写入数据，写入完成后回收任务ID
+func Write(con net.Conn, writeType int, byteData []byte, pool utils.TaskIDPool) error {
+	return WriteWithConfig(con, writeType, byteData, pool, DefaultWriterConfig)
+}
+
+// WriteWithConfig 按写入配置从任务ID池获取任务ID并分片写入数据，写入完成后回收任务ID
+func WriteWithConfig(con net.Conn, writeType int, byteData []byte, pool utils.TaskIDPool, config WriterConfig) error {
+	taskID, err := pool.GetTaskID()
+	if err != nil {
+		return err
+	}
+	defer pool.RecycleTaskID(taskID)
+	return WriteTaskWithConfig(con, writeType, taskID, byteData, config)
+}
+
+// WriteTask 使用指定的任务ID分片写入数据，调用方负责任务ID的分配与回收
+func WriteTask(con net.Conn, writeType int, taskID int, byteData []byte) error {
+	return WriteTaskWithConfig(con, writeType, taskID, byteData, DefaultWriterConfig)
+}
+
+// WriteTaskWithConfig 按写入配置使用指定的任务ID分片写入数据，
+// 不为连接保留任何状态，不支持协议协商与重传，长期持有连接时建议直接使用 NewWriter
+func WriteTaskWithConfig(con net.Conn, writeType int, taskID int, byteData []byte, config WriterConfig) error {
+	config, err := config.withDefaults()
+	if err != nil {
+		return err
+	}
+	w := &Writer{conn: con, config: config, version: ProtocolVersion, shared: &packageSeq}
+	return w.writeTask(writeType, taskID, byteData, config)
+}
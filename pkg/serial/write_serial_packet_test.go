@@ -0,0 +1,117 @@
+package serial
+
+import (
+	"bytes"
+	"crypto/rand"
+	"net"
+	"testing"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+)
+
+// valueConn 按值传递且不可比较的连接（含切片字段），作为 map 键或用 == 比较会 panic
+type valueConn struct {
+	net.Conn
+	rec  *frameRecorder
+	tags []string
+}
+
+func (c valueConn) Write(p []byte) (int, error) { return c.rec.Write(p) }
+
+func (c valueConn) Read([]byte) (int, error) { return 0, net.ErrClosed }
+
+func (c valueConn) Close() error { return nil }
+
+func TestPackageWriteValueConn(t *testing.T) {
+	conn := valueConn{rec: &frameRecorder{}, tags: []string{"serial"}}
+	config := WriterConfig{Pacer: NoPacer, MaxPayload: 100}
+	for _, n := range []int{250, 30} {
+		if err := WriteTaskWithConfig(conn, 1, 7, payload(n), config); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ReleaseWriter(conn)
+
+	if len(conn.rec.frames) != 4 {
+		t.Fatalf("got %d frames, want 4", len(conn.rec.frames))
+	}
+	var last uint32
+	for i, frame := range conn.rec.frames {
+		var packet protocol.DataPacket
+		if err := packet.UnmarshalBinary(frame); err != nil {
+			t.Fatal(err)
+		}
+		// 同一条数据的各帧序列号连续，后一条数据的序列号更大
+		seq := packet.Header.SeqNum
+		if i == 1 || i == 2 {
+			if seq != last+1 {
+				t.Fatalf("frame %d seq %d, want %d", i, seq, last+1)
+			}
+		} else if i == 3 && seq <= last {
+			t.Fatalf("frame %d seq %d not after %d", i, seq, last)
+		}
+		last = seq
+	}
+}
+
+func TestAgentRegistryValueConn(t *testing.T) {
+	r := NewAgentRegistry()
+	conn := valueConn{rec: &frameRecorder{}, tags: []string{"serial"}}
+	r.Register("vm", ChannelSerial, conn)
+	r.Move("vm", ChannelSerial, ChannelTask, conn)
+	if agent, _ := r.Lookup("vm"); agent.Conns[ChannelTask] == nil {
+		t.Fatalf("conn not moved: %v", agent.Conns)
+	}
+	r.Unregister("vm", ChannelTask, conn)
+	if _, ok := r.Lookup("vm"); ok {
+		t.Fatal("agent still registered after unregister")
+	}
+}
+
+func TestWriterCompressThreshold(t *testing.T) {
+	random := make([]byte, DefaultCompressThreshold)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		data       []byte
+		caps       uint32
+		compressed bool
+	}{
+		{name: "below threshold", data: payload(DefaultCompressThreshold - 1), caps: protocol.CapGzip},
+		{name: "at threshold", data: payload(DefaultCompressThreshold), caps: protocol.CapGzip, compressed: true},
+		{name: "large", data: payload(20 * DefaultCompressThreshold), caps: protocol.CapGzip, compressed: true},
+		{name: "incompressible", data: random, caps: protocol.CapGzip},
+		{name: "peer without gzip", data: payload(DefaultCompressThreshold)},
+	}
+	for _, tt := range tests {
+		rec := &frameRecorder{}
+		w, err := NewWriter(rec, nil, WriterConfig{Pacer: NoPacer, MaxPayload: 4096})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = w.SetNegotiated(protocol.ProtocolVersion2, tt.caps); err != nil {
+			t.Fatal(err)
+		}
+		if err = w.WriteTask(1, 9, tt.data); err != nil {
+			t.Fatal(err)
+		}
+
+		var first protocol.DataPacket
+		if err = first.UnmarshalBinary(rec.frames[0]); err != nil {
+			t.Fatal(err)
+		}
+		if got := first.Header.Flags&protocol.FlagCompressed != 0; got != tt.compressed {
+			t.Errorf("%s: compressed %v, want %v", tt.name, got, tt.compressed)
+		}
+
+		// 接收方按首帧标志位解压，交付原始数据
+		var messages []Message
+		var partials []PartialMessage
+		newTestSession(&messages, &partials).newSerialReader().process(bytes.Join(rec.frames, nil))
+		if len(messages) != 1 || !bytes.Equal(messages[0].Data, tt.data) {
+			t.Errorf("%s: got %d messages, want the original data", tt.name, len(messages))
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package serial
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/utils"
+)
+
+// 写入默认配置，与旧版探针保持兼容
+const (
+	DefaultMaxPayload        = 300
+	DefaultFrameInterval     = 20 * time.Millisecond
+	DefaultCompressThreshold = 1024
+	DefaultAckTimeout        = 2 * time.Second
+	DefaultMaxRetransmits    = 3
+)
+
+// Pacer 帧间节奏控制，每帧写入后调用
+type Pacer interface {
+	Pace(frameLen int)
+}
+
+// FixedPacer 每帧写入后固定等待一段时间
+type FixedPacer time.Duration
+
+// Pace 等待固定时间
+func (p FixedPacer) Pace(int) {
+	if p > 0 {
+		time.Sleep(time.Duration(p))
+	}
+}
+
+// NoPacer 帧间不等待，适用于 virtio-serial 等不会丢帧的高速通道
+var NoPacer Pacer = FixedPacer(0)
+
+// WriterConfig 写入配置，按连接选择；零值即默认配置：
+// 单帧 300 字节数据、帧间隔 20ms、不限速，与旧版探针保持兼容
+type WriterConfig struct {
+	MaxPayload int                // 单帧最大数据长度，默认 DefaultMaxPayload，最大 protocol.MaxDataLenV2，使用 v1 协议时不超过 protocol.MaxDataLen
+	Pacer      Pacer              // 帧间节奏控制，默认 FixedPacer(DefaultFrameInterval)
+	Limiter    *utils.RateLimiter // 令牌桶限速（按帧字节数），nil 表示不限速
+
+	// RetransmitWindow 等待 Ack 超时后重发的数据尾部帧数，0 表示不启用可靠传输；
+	// 启用且握手协商双方都支持可靠传输时，保留当前数据的全部已发送帧响应接收方的 Nack，收到 Ack 后释放
+	RetransmitWindow int
+	// AckTimeout 启用可靠传输时，数据发送完成后等待接收方 Ack 的时间，超时后重发数据尾部的帧，
+	// 默认 DefaultAckTimeout
+	AckTimeout time.Duration
+	// MaxRetransmits 等待 Ack 超时后最多重发的次数，之后放弃并释放窗口，默认 DefaultMaxRetransmits，负数表示不重发
+	MaxRetransmits int
+
+	// CompressThreshold 握手协商 v2 协议且对端支持 gzip 后，不小于该长度的数据压缩传输，
+	// 默认 DefaultCompressThreshold，负数表示不压缩；压缩后未变小的数据按原样发送
+	CompressThreshold int
+
+	// Sealer 设置后所有帧使用 v2 头部并经 AEAD 加密认证，接收方须配置相同的预共享密钥
+	Sealer *protocol.Sealer
+}
+
+// DefaultWriterConfig 默认写入配置
+var DefaultWriterConfig = WriterConfig{}
+
+// withDefaults 填充默认配置并校验
+func (c WriterConfig) withDefaults() (WriterConfig, error) {
+	if c.MaxPayload == 0 {
+		c.MaxPayload = DefaultMaxPayload
+	}
+	if c.MaxPayload < 0 || c.MaxPayload > protocol.MaxDataLenV2 {
+		return c, fmt.Errorf("单帧最大数据长度 %d 超出范围 1~%d", c.MaxPayload, protocol.MaxDataLenV2)
+	}
+	if c.RetransmitWindow < 0 {
+		return c, fmt.Errorf("重传窗口大小 %d 不能为负数", c.RetransmitWindow)
+	}
+	if c.AckTimeout <= 0 {
+		c.AckTimeout = DefaultAckTimeout
+	}
+	if c.MaxRetransmits == 0 {
+		c.MaxRetransmits = DefaultMaxRetransmits
+	}
+	if c.CompressThreshold == 0 {
+		c.CompressThreshold = DefaultCompressThreshold
+	}
+	if c.Pacer == nil {
+		c.Pacer = FixedPacer(DefaultFrameInterval)
+	}
+	return c, nil
+}
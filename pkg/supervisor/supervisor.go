@@ -0,0 +1,339 @@
+// Package supervisor 监视 chardev socket 目录，按虚拟机自动启动与停止各通道的监听。
+//
+// 虚拟化平台为每台虚拟机创建 <kvmID>.fa（任务通道）、<kvmID>.fa2（数据采集通道）与
+// <kvmID>.fa00（物理串口通道）三个 socket，Supervisor 在 socket 出现时建立连接并监听，
+// 连接断开后按退避重连，socket 删除时停止监听；同一虚拟机的各通道共用一个 serial.Session，
+// 探针通过 serial.AgentRegistry 上报
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/serial"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/transport"
+	"go.uber.org/zap"
+)
+
+// DefaultPollInterval 无法使用 inotify 时扫描目录的间隔
+const DefaultPollInterval = 2 * time.Second
+
+// socket 文件后缀与通道的对应关系
+var suffixes = map[string]serial.Channel{
+	".fa":   serial.ChannelTask,
+	".fa2":  serial.ChannelCollect,
+	".fa00": serial.ChannelSerial,
+}
+
+// ParseSocketName 解析 socket 文件名，返回虚拟机ID与通道；后缀不是 .fa、.fa2、.fa00 时 ok 为 false
+func ParseSocketName(name string) (kvmID string, channel serial.Channel, ok bool) {
+	ext := filepath.Ext(name)
+	channel, ok = suffixes[ext]
+	kvmID = name[:len(name)-len(ext)]
+	if !ok || kvmID == "" {
+		return "", 0, false
+	}
+	return kvmID, channel, true
+}
+
+// Config 目录监视配置
+type Config struct {
+	Dir      string      // socket 所在目录，必填
+	Network  string      // 连接类型，默认 unix
+	Logger   *zap.Logger // 日志，默认不输出
+	Registry *serial.AgentRegistry
+	// Handler 完整数据处理函数，Session 为 nil 时使用
+	Handler serial.ProcessMessageFunc
+	// Session 为新发现的虚拟机创建会话配置，Registry 字段由 Supervisor 填充；
+	// 配置 Writer 时物理串口通道每次建立连接后写入器切换到新连接
+	Session func(kvmID string) serial.SessionConfig
+	// Handshake 物理串口通道每次建立连接后与探针握手协商协议版本，需在会话配置中设置 Writer；
+	// 会话配置 Sealer 时始终握手
+	Handshake bool
+
+	Backoff      transport.Backoff        // 连接断开后的重连退避，零值使用默认配置
+	OnState      func(serial.StateChange) // 连接状态变化回调，默认记录日志
+	PollInterval time.Duration            // 无法使用 inotify 时扫描目录的间隔，默认 DefaultPollInterval
+}
+
+// Supervisor socket 目录监视器
+type Supervisor struct {
+	config   Config
+	log      *zap.Logger
+	registry *serial.AgentRegistry
+
+	mu        sync.Mutex
+	listeners map[socketKey]*listener
+	sessions  map[string]*agentSession
+}
+
+// socketKey 虚拟机的一个通道
+type socketKey struct {
+	kvmID   string
+	channel serial.Channel
+}
+
+// listener 正在监听的通道
+type listener struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// agentSession 虚拟机共用的会话及正在监听的通道数
+type agentSession struct {
+	session   *serial.Session
+	writer    *serial.Writer // 会话配置的写入器
+	handshake bool           // 物理串口通道建立连接后握手
+	listeners int
+}
+
+// New 创建目录监视器，Registry 为 nil 时创建新的注册表
+func New(config Config) (*Supervisor, error) {
+	if config.Dir == "" {
+		return nil, errors.New("socket 目录不能为空")
+	}
+	if config.Network == "" {
+		config.Network = "unix"
+	}
+	if config.PollInterval <= 0 {
+		config.PollInterval = DefaultPollInterval
+	}
+	log := config.Logger
+	if log == nil {
+		log = zap.NewNop()
+	}
+	registry := config.Registry
+	if registry == nil {
+		registry = serial.NewAgentRegistry()
+	}
+	return &Supervisor{
+		config:    config,
+		log:       log.With(zap.String("dir", config.Dir)),
+		registry:  registry,
+		listeners: make(map[socketKey]*listener),
+		sessions:  make(map[string]*agentSession),
+	}, nil
+}
+
+// Registry 探针注册表
+func (s *Supervisor) Registry() *serial.AgentRegistry {
+	return s.registry
+}
+
+// Session 虚拟机的会话，虚拟机没有正在监听的通道时 ok 为 false
+func (s *Supervisor) Session(kvmID string) (session *serial.Session, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	agent, ok := s.sessions[kvmID]
+	if !ok {
+		return nil, false
+	}
+	return agent.session, true
+}
+
+// Run 监视目录直到 ctx 取消，退出前停止所有监听；Linux 下使用 inotify，不可用时定期扫描目录
+func (s *Supervisor) Run(ctx context.Context) error {
+	w, err := newWatcher(s.config.Dir, s.config.PollInterval)
+	if err != nil {
+		s.log.Warn("无法监视目录变化，改为定期扫描", zap.Error(err))
+		w = newPollWatcher(s.config.PollInterval)
+	}
+	defer w.Close()
+	defer s.stopAll()
+
+	if err = s.scan(ctx); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case _, ok := <-w.Changes():
+			if !ok {
+				return errors.New("目录监视已停止")
+			}
+			if err = s.scan(ctx); err != nil {
+				s.log.Warn("扫描 socket 目录失败", zap.Error(err))
+			}
+		}
+	}
+}
+
+// scan 扫描目录，为新出现的 socket 启动监听，停止已删除 socket 的监听
+func (s *Supervisor) scan(ctx context.Context) error {
+	entries, err := os.ReadDir(s.config.Dir)
+	if err != nil {
+		return err
+	}
+	present := make(map[socketKey]bool)
+	for _, entry := range entries {
+		if entry.Type()&os.ModeSocket == 0 {
+			continue
+		}
+		kvmID, channel, ok := ParseSocketName(entry.Name())
+		if !ok {
+			continue
+		}
+		present[socketKey{kvmID: kvmID, channel: channel}] = true
+	}
+
+	s.mu.Lock()
+	var stopped []*listener
+	for key, l := range s.listeners {
+		if !present[key] {
+			stopped = append(stopped, s.remove(key, l))
+		}
+	}
+	for key := range present {
+		if _, ok := s.listeners[key]; !ok {
+			s.start(ctx, key)
+		}
+	}
+	s.mu.Unlock()
+
+	for _, l := range stopped {
+		<-l.done
+	}
+	return nil
+}
+
+// start 启动通道监听，调用方须持有 s.mu
+func (s *Supervisor) start(ctx context.Context, key socketKey) {
+	agent, ok := s.sessions[key.kvmID]
+	if !ok {
+		config := s.sessionConfig(key.kvmID)
+		agent = &agentSession{
+			session:   serial.NewSession(key.kvmID, config),
+			writer:    config.Writer,
+			handshake: config.Writer != nil && (s.config.Handshake || config.Sealer != nil),
+		}
+		s.sessions[key.kvmID] = agent
+	}
+	agent.listeners++
+
+	ctx, cancel := context.WithCancel(ctx)
+	l := &listener{cancel: cancel, done: make(chan struct{})}
+	s.listeners[key] = l
+	address := filepath.Join(s.config.Dir, key.kvmID+key.channel.String())
+	s.log.Info("发现探针通道", zap.String("kvmID", key.kvmID), zap.Stringer("channel", key.channel))
+
+	go func() {
+		defer close(l.done)
+		_ = agent.session.ServeReconnect(ctx, key.channel, serial.ReconnectConfig{
+			Dial:      transport.NewDialer(s.config.Network, address, transport.Config{}),
+			Backoff:   s.config.Backoff,
+			OnConnect: agent.onConnect(key.channel),
+			OnState:   s.config.OnState,
+		})
+	}()
+}
+
+// onConnect 通道建立连接后的处理：物理串口通道的写入器切换到新连接并按配置握手，其他通道不处理
+func (a *agentSession) onConnect(channel serial.Channel) func(io.ReadWriteCloser) error {
+	if channel != serial.ChannelSerial || a.writer == nil {
+		return nil
+	}
+	return func(conn io.ReadWriteCloser) error {
+		a.writer.SetConn(conn)
+		if !a.handshake {
+			return nil
+		}
+		if err := a.session.Handshake(); err != nil {
+			return fmt.Errorf("协议握手失败: %w", err)
+		}
+		return nil
+	}
+}
+
+// remove 停止通道监听并返回该监听，调用方须持有 s.mu，释放锁后等待监听退出
+func (s *Supervisor) remove(key socketKey, l *listener) *listener {
+	l.cancel()
+	delete(s.listeners, key)
+	if agent := s.sessions[key.kvmID]; agent != nil {
+		agent.listeners--
+		if agent.listeners == 0 {
+			delete(s.sessions, key.kvmID)
+		}
+	}
+	s.log.Info("探针通道已移除", zap.String("kvmID", key.kvmID), zap.Stringer("channel", key.channel))
+	return l
+}
+
+// stopAll 停止所有监听并等待退出
+func (s *Supervisor) stopAll() {
+	s.mu.Lock()
+	var stopped []*listener
+	for key, l := range s.listeners {
+		stopped = append(stopped, s.remove(key, l))
+	}
+	s.mu.Unlock()
+	for _, l := range stopped {
+		<-l.done
+	}
+}
+
+// sessionConfig 新虚拟机的会话配置
+func (s *Supervisor) sessionConfig(kvmID string) serial.SessionConfig {
+	config := serial.SessionConfig{Logger: s.config.Logger, Handler: s.config.Handler}
+	if s.config.Session != nil {
+		config = s.config.Session(kvmID)
+	}
+	config.Registry = s.registry
+	return config
+}
+
+// watcher 目录变化通知
+type watcher interface {
+	Changes() <-chan struct{} // 目录可能发生变化
+	Close() error
+}
+
+// pollWatcher 定期通知扫描目录
+type pollWatcher struct {
+	ticker  *time.Ticker
+	changes chan struct{}
+	done    chan struct{}
+	once    sync.Once
+}
+
+// newPollWatcher 创建定期扫描的目录监视
+func newPollWatcher(interval time.Duration) *pollWatcher {
+	w := &pollWatcher{ticker: time.NewTicker(interval), changes: make(chan struct{}, 1), done: make(chan struct{})}
+	go func() {
+		for {
+			select {
+			case <-w.ticker.C:
+				notify(w.changes)
+			case <-w.done:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+func (w *pollWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+func (w *pollWatcher) Close() error {
+	w.once.Do(func() {
+		w.ticker.Stop()
+		close(w.done)
+	})
+	return nil
+}
+
+// notify 发送变化通知，已有未处理的通知时合并
+func notify(changes chan struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
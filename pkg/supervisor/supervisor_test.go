@@ -0,0 +1,166 @@
+package supervisor
+
+import (
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/protocol"
+	"github.com/xuchao-ovo/agent-sdk-go/pkg/serial"
+)
+
+func TestParseSocketName(t *testing.T) {
+	tests := []struct {
+		name    string
+		kvmID   string
+		channel serial.Channel
+		ok      bool
+	}{
+		{name: "vm1.fa", kvmID: "vm1", channel: serial.ChannelTask, ok: true},
+		{name: "vm1.fa2", kvmID: "vm1", channel: serial.ChannelCollect, ok: true},
+		{name: "vm1.fa00", kvmID: "vm1", channel: serial.ChannelSerial, ok: true},
+		{name: "vm.v2.fa00", kvmID: "vm.v2", channel: serial.ChannelSerial, ok: true},
+		{name: ".fa", ok: false},
+		{name: "vm1.sock", ok: false},
+		{name: "vm1", ok: false},
+		{name: "vm1.fa3", ok: false},
+	}
+	for _, tt := range tests {
+		kvmID, channel, ok := ParseSocketName(tt.name)
+		if kvmID != tt.kvmID || channel != tt.channel || ok != tt.ok {
+			t.Errorf("%s: got (%q, %s, %v), want (%q, %s, %v)", tt.name, kvmID, channel, ok, tt.kvmID, tt.channel, tt.ok)
+		}
+	}
+}
+
+// listenSocket 在 dir 中创建 socket，接受的连接发送到返回的通道
+func listenSocket(t *testing.T, dir, name string) (net.Listener, <-chan net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("unix", filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan net.Conn, 8)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conns <- conn
+		}
+	}()
+	return listener, conns
+}
+
+// accept 等待 socket 接受连接
+func accept(t *testing.T, conns <-chan net.Conn) net.Conn {
+	t.Helper()
+	select {
+	case conn := <-conns:
+		return conn
+	case <-time.After(5 * time.Second):
+		t.Fatal("supervisor did not connect")
+		return nil
+	}
+}
+
+// waitChannels 等待注册表中探针登记的通道为 want
+func waitChannels(t *testing.T, registry *serial.AgentRegistry, kvmID string, want []serial.Channel) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		agent, _ := registry.Lookup(kvmID)
+		got := agent.Channels()
+		if reflect.DeepEqual(got, want) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("got channels %v, want %v", got, want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestSupervisorScan(t *testing.T) {
+	dir := t.TempDir()
+	task, taskConns := listenSocket(t, dir, "vm1.fa")
+	defer task.Close()
+	serialListener, serialConns := listenSocket(t, dir, "vm1.fa00")
+	defer serialListener.Close()
+	// 普通文件与其他后缀的 socket 被忽略
+	if err := os.WriteFile(filepath.Join(dir, "vm2.fa"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	other, _ := listenSocket(t, dir, "vm3.sock")
+	defer other.Close()
+
+	writer, err := serial.NewWriter(nil, nil, serial.WriterConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := New(Config{
+		Dir:       dir,
+		Handshake: true,
+		Session: func(kvmID string) serial.SessionConfig {
+			return serial.SessionConfig{Writer: writer, OnEvent: func(serial.Event) {}}
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err = s.scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(s.listeners); got != 2 {
+		t.Fatalf("got %d listeners, want 2", got)
+	}
+	if _, ok := s.Session("vm1"); !ok {
+		t.Fatal("session for vm1 not started")
+	}
+
+	// 物理串口通道建立连接后写入器切换到新连接并发送握手数据包
+	accept(t, taskConns)
+	conn := accept(t, serialConns)
+	header := make([]byte, protocol.HeaderSize)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err = io.ReadFull(conn, header); err != nil {
+		t.Fatalf("read hello: %v", err)
+	}
+	if header[0] != 0xCA || header[1] != 0xFE || header[7] != protocol.PacketTypeHello {
+		t.Fatalf("got header % x, want a hello packet", header)
+	}
+	waitChannels(t, s.Registry(), "vm1", []serial.Channel{serial.ChannelTask, serial.ChannelSerial})
+
+	// socket 删除后停止该通道的监听，虚拟机仍有通道时保留会话
+	_ = serialListener.Close()
+	if err = s.scan(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.listeners[socketKey{kvmID: "vm1", channel: serial.ChannelSerial}]; ok {
+		t.Fatal("listener for removed socket not stopped")
+	}
+	if _, ok := s.Session("vm1"); !ok {
+		t.Fatal("session removed while task channel still listening")
+	}
+	waitChannels(t, s.Registry(), "vm1", []serial.Channel{serial.ChannelTask})
+
+	// 停止所有监听后移除会话，探针从注册表注销
+	s.stopAll()
+	if len(s.listeners) != 0 {
+		t.Fatalf("got %d listeners after stopAll", len(s.listeners))
+	}
+	if _, ok := s.Session("vm1"); ok {
+		t.Fatal("session kept after stopAll")
+	}
+	if n := s.Registry().Len(); n != 0 {
+		t.Fatalf("got %d registered agents after stopAll, want 0", n)
+	}
+}
@@ -0,0 +1,55 @@
+//go:build linux
+
+package supervisor
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// inotifyMask 目录中文件创建、删除、移入移出，以及目录自身被删除或移动
+const inotifyMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO |
+	syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// inotifyWatcher 使用 inotify 监视目录，事件只作为重新扫描的信号
+type inotifyWatcher struct {
+	file    *os.File
+	changes chan struct{}
+}
+
+// newWatcher 创建目录监视，inotify 不可用时返回错误
+func newWatcher(dir string, _ time.Duration) (watcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_NONBLOCK | syscall.IN_CLOEXEC)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	if _, err = syscall.InotifyAddWatch(fd, dir, inotifyMask); err != nil {
+		_ = syscall.Close(fd)
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+	// 非阻塞的描述符交由运行时轮询，Close 可使阻塞的 Read 返回
+	w := &inotifyWatcher{file: os.NewFile(uintptr(fd), "inotify"), changes: make(chan struct{}, 1)}
+	go w.read()
+	return w, nil
+}
+
+// read 读取 inotify 事件直到描述符关闭，关闭时关闭通知通道
+func (w *inotifyWatcher) read() {
+	defer close(w.changes)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		if _, err := w.file.Read(buf); err != nil {
+			return
+		}
+		notify(w.changes)
+	}
+}
+
+func (w *inotifyWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
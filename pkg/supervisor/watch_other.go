@@ -0,0 +1,10 @@
+//go:build !linux
+
+package supervisor
+
+import "time"
+
+// newWatcher 创建目录监视，当前平台定期扫描目录
+func newWatcher(_ string, interval time.Duration) (watcher, error) {
+	return newPollWatcher(interval), nil
+}
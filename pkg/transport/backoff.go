@@ -0,0 +1,130 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// 重连退避默认配置
+const (
+	DefaultBackoffInitial    = 500 * time.Millisecond
+	DefaultBackoffMax        = 30 * time.Second
+	DefaultBackoffMultiplier = 2.0
+	DefaultBackoffJitter     = 0.2
+	DefaultBackoffResetAfter = 10 * time.Second
+)
+
+// Backoff 指数退避配置，零值即默认配置：首次等待 500ms，每次翻倍，最长 30s，随机抖动 ±20%，
+// 连接保持 10s 以上视为稳定
+type Backoff struct {
+	Initial    time.Duration // 首次重连前的等待时间
+	Max        time.Duration // 最长等待时间
+	Multiplier float64       // 每次失败后等待时间的倍数
+	Jitter     float64       // 随机抖动比例 0~1，避免多个连接同时重连，负数表示不抖动
+	// ResetAfter 连接保持该时间以上后断开才重新从初始等待时间开始，
+	// 更早断开（如对端接受连接后立即关闭）视为失败继续退避
+	ResetAfter time.Duration
+	// Clock 计时与等待使用的时钟，nil 表示系统时钟，测试时可替换
+	Clock Clock
+}
+
+// Clock 时钟
+type Clock interface {
+	Now() time.Time
+	// Sleep 等待 d，ctx 取消时提前返回 ctx 的错误
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// SystemClock 系统时钟
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// withDefaults 填充默认配置
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial <= 0 {
+		b.Initial = DefaultBackoffInitial
+	}
+	if b.Max <= 0 {
+		b.Max = DefaultBackoffMax
+	}
+	if b.Max < b.Initial {
+		b.Max = b.Initial
+	}
+	if b.Multiplier < 1 {
+		b.Multiplier = DefaultBackoffMultiplier
+	}
+	if b.Jitter == 0 || b.Jitter > 1 {
+		b.Jitter = DefaultBackoffJitter
+	}
+	if b.ResetAfter <= 0 {
+		b.ResetAfter = DefaultBackoffResetAfter
+	}
+	if b.Clock == nil {
+		b.Clock = SystemClock
+	}
+	return b
+}
+
+// Now 退避时钟的当前时间，用于计算连接保持的时间
+func (b Backoff) Now() time.Time {
+	return b.withDefaults().Clock.Now()
+}
+
+// Stable 保持 uptime 后断开的连接是否视为稳定，稳定连接断开后退避重新开始
+func (b Backoff) Stable(uptime time.Duration) bool {
+	return uptime >= b.withDefaults().ResetAfter
+}
+
+// Delay 第 attempt 次（从 0 开始）重连前的等待时间
+func (b Backoff) Delay(attempt int) time.Duration {
+	b = b.withDefaults()
+	delay := float64(b.Initial)
+	for i := 0; i < attempt && delay < float64(b.Max); i++ {
+		delay *= b.Multiplier
+	}
+	if delay > float64(b.Max) {
+		delay = float64(b.Max)
+	}
+	if b.Jitter > 0 {
+		delay *= 1 + b.Jitter*(2*rand.Float64()-1)
+	}
+	return time.Duration(delay)
+}
+
+// Wait 等待第 attempt 次重连前的退避时间，ctx 取消时提前返回 ctx 的错误
+func (b Backoff) Wait(ctx context.Context, attempt int) error {
+	return b.withDefaults().Clock.Sleep(ctx, b.Delay(attempt))
+}
+
+// Dialer 建立探针连接
+type Dialer func(ctx context.Context) (io.ReadWriteCloser, error)
+
+// NewDialer 按地址创建 Dialer：物理串口设备按 config 打开，其他地址按 network 建立连接
+func NewDialer(network, address string, config Config) Dialer {
+	return func(ctx context.Context) (io.ReadWriteCloser, error) {
+		if IsTTY(address) {
+			return OpenTTY(address, config)
+		}
+		var d net.Dialer
+		return d.DialContext(ctx, network, address)
+	}
+}
@@ -0,0 +1,89 @@
+package transport
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		backoff Backoff
+		attempt int
+		want    time.Duration
+	}{
+		{name: "initial", backoff: Backoff{Jitter: -1}, attempt: 0, want: DefaultBackoffInitial},
+		{name: "doubled", backoff: Backoff{Jitter: -1}, attempt: 2, want: 4 * DefaultBackoffInitial},
+		{name: "capped", backoff: Backoff{Jitter: -1}, attempt: 20, want: DefaultBackoffMax},
+		{name: "custom", backoff: Backoff{Initial: time.Second, Max: 5 * time.Second, Multiplier: 3, Jitter: -1}, attempt: 1, want: 3 * time.Second},
+	}
+	for _, tt := range tests {
+		for i := 0; i < 10; i++ {
+			if got := tt.backoff.Delay(tt.attempt); got != tt.want {
+				t.Fatalf("%s: got %v, want %v", tt.name, got, tt.want)
+			}
+		}
+	}
+
+	// 默认抖动 ±20%
+	for i := 0; i < 100; i++ {
+		got := Backoff{}.Delay(0)
+		if got < DefaultBackoffInitial*8/10 || got > DefaultBackoffInitial*12/10 {
+			t.Fatalf("jittered delay %v out of range", got)
+		}
+	}
+}
+
+func TestBackoffStable(t *testing.T) {
+	if (Backoff{}).Stable(time.Second) {
+		t.Error("1s connection treated as stable with default ResetAfter")
+	}
+	if !(Backoff{}).Stable(DefaultBackoffResetAfter) {
+		t.Error("connection kept for ResetAfter not treated as stable")
+	}
+	if !(Backoff{ResetAfter: time.Millisecond}).Stable(time.Second) {
+		t.Error("custom ResetAfter ignored")
+	}
+}
+
+// recordClock 记录等待时间的假时钟
+type recordClock struct {
+	now    time.Time
+	sleeps []time.Duration
+}
+
+func (c *recordClock) Now() time.Time {
+	return c.now
+}
+
+func (c *recordClock) Sleep(_ context.Context, d time.Duration) error {
+	c.sleeps = append(c.sleeps, d)
+	c.now = c.now.Add(d)
+	return nil
+}
+
+func TestBackoffWaitClock(t *testing.T) {
+	clock := &recordClock{}
+	b := Backoff{Initial: time.Second, Jitter: -1, Clock: clock}
+	for attempt := 0; attempt < 4; attempt++ {
+		if err := b.Wait(context.Background(), attempt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second}
+	if !reflect.DeepEqual(clock.sleeps, want) {
+		t.Fatalf("got sleeps %v, want %v", clock.sleeps, want)
+	}
+	if got := b.Now(); !got.Equal(time.Time{}.Add(15 * time.Second)) {
+		t.Fatalf("got now %v, want the injected clock", got)
+	}
+
+	// 系统时钟等待可被 ctx 取消
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := (Backoff{Initial: time.Hour}).Wait(ctx, 0); err != context.Canceled {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+}
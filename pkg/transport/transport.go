@@ -0,0 +1,80 @@
+// Package transport 探针通信的传输层：Unix socket、TCP 等 net.Conn 与物理串口（TTY），
+// 均以 io.ReadWriteCloser 交给 serial 包的会话与写入器使用
+package transport
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+// ErrUnsupported 当前平台不支持物理串口
+var ErrUnsupported = errors.New("当前平台不支持物理串口")
+
+// Parity 校验位
+type Parity int
+
+const (
+	ParityNone Parity = iota // 无校验
+	ParityOdd                // 奇校验
+	ParityEven               // 偶校验
+)
+
+// StopBits 停止位
+type StopBits int
+
+const (
+	StopBits1 StopBits = iota // 1 位停止位
+	StopBits2                 // 2 位停止位
+)
+
+// 物理串口默认配置：115200 8N1
+const (
+	DefaultBaudRate = 115200
+	DefaultDataBits = 8
+)
+
+// Config 物理串口配置，零值即默认配置 115200 8N1；串口始终设置为 raw 模式（不回显、不处理控制字符、按字节读取）
+type Config struct {
+	BaudRate int      // 波特率，默认 DefaultBaudRate
+	DataBits int      // 数据位 5~8，默认 DefaultDataBits
+	Parity   Parity   // 校验位，默认无校验
+	StopBits StopBits // 停止位，默认 1 位
+}
+
+// withDefaults 填充默认配置并校验
+func (c Config) withDefaults() (Config, error) {
+	if c.BaudRate == 0 {
+		c.BaudRate = DefaultBaudRate
+	}
+	if c.DataBits == 0 {
+		c.DataBits = DefaultDataBits
+	}
+	if c.DataBits < 5 || c.DataBits > 8 {
+		return c, fmt.Errorf("数据位 %d 超出范围 5~8", c.DataBits)
+	}
+	if c.Parity < ParityNone || c.Parity > ParityEven {
+		return c, fmt.Errorf("无效的校验位: %d", c.Parity)
+	}
+	if c.StopBits < StopBits1 || c.StopBits > StopBits2 {
+		return c, fmt.Errorf("无效的停止位: %d", c.StopBits)
+	}
+	return c, nil
+}
+
+// IsTTY 地址是否为物理串口设备（/dev/tty*、/dev/serial/*、/dev/pts/*）
+func IsTTY(address string) bool {
+	return strings.HasPrefix(address, "/dev/tty") ||
+		strings.HasPrefix(address, "/dev/serial/") ||
+		strings.HasPrefix(address, "/dev/pts/")
+}
+
+// Open 打开探针连接：物理串口设备按 config 配置后打开，其他地址按 network（unix、tcp 等）建立连接
+func Open(network, address string, config Config) (io.ReadWriteCloser, error) {
+	if IsTTY(address) {
+		return OpenTTY(address, config)
+	}
+	return net.Dial(network, address)
+}
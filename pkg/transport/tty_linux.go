@@ -0,0 +1,164 @@
+//go:build linux
+
+package transport
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// baudRates 波特率对应的 termios 速率常量
+var baudRates = map[int]uint32{
+	1200:    syscall.B1200,
+	2400:    syscall.B2400,
+	4800:    syscall.B4800,
+	9600:    syscall.B9600,
+	19200:   syscall.B19200,
+	38400:   syscall.B38400,
+	57600:   syscall.B57600,
+	115200:  syscall.B115200,
+	230400:  syscall.B230400,
+	460800:  syscall.B460800,
+	921600:  syscall.B921600,
+	1000000: syscall.B1000000,
+	1500000: syscall.B1500000,
+	2000000: syscall.B2000000,
+	3000000: syscall.B3000000,
+	4000000: syscall.B4000000,
+}
+
+// speedMask termios 中表示速率的位，syscall 未导出 CBAUD，由各速率常量合并得到
+var speedMask = func() uint32 {
+	var mask uint32
+	for _, speed := range baudRates {
+		mask |= speed
+	}
+	return mask
+}()
+
+// dataBits 数据位对应的 termios 标志
+var dataBits = map[int]uint32{
+	5: syscall.CS5,
+	6: syscall.CS6,
+	7: syscall.CS7,
+	8: syscall.CS8,
+}
+
+// OpenTTY 打开物理串口设备并设置为 raw 模式，返回的 *os.File 关闭时会中断阻塞的读取
+func OpenTTY(path string, config Config) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err = Configure(f, config); err != nil {
+		_ = f.Close()
+		return nil, fmt.Errorf("配置串口[%s]失败: %w", path, err)
+	}
+	return f, nil
+}
+
+// Configure 按 config 设置串口的波特率、数据位、校验位与停止位，并切换到 raw 模式
+func Configure(f *os.File, config Config) error {
+	config, err := config.withDefaults()
+	if err != nil {
+		return err
+	}
+	speed, ok := baudRates[config.BaudRate]
+	if !ok {
+		return fmt.Errorf("不支持的波特率: %d", config.BaudRate)
+	}
+
+	return control(f, func(fd uintptr) error {
+		var t syscall.Termios
+		if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+			return err
+		}
+		makeRaw(&t)
+
+		t.Cflag &^= syscall.CSIZE | syscall.PARENB | syscall.PARODD | syscall.CSTOPB | speedMask
+		t.Cflag |= dataBits[config.DataBits] | speed | syscall.CLOCAL | syscall.CREAD
+		switch config.Parity {
+		case ParityOdd:
+			t.Cflag |= syscall.PARENB | syscall.PARODD
+		case ParityEven:
+			t.Cflag |= syscall.PARENB
+		}
+		if config.StopBits == StopBits2 {
+			t.Cflag |= syscall.CSTOPB
+		}
+		// TCSETS 按 Cflag 中的速率位设置波特率；部分架构（如 mips）的 syscall.Termios 没有 Ispeed/Ospeed 字段，不能设置
+		return ioctl(fd, syscall.TCSETS, unsafe.Pointer(&t))
+	})
+}
+
+// OpenPTY 创建一对伪终端，主端与从端均为 raw 模式，用于在没有物理串口时模拟探针
+func OpenPTY() (master, slave *os.File, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	var n uint32
+	err = control(master, func(fd uintptr) error {
+		var unlock int32
+		if err := ioctl(fd, syscall.TIOCSPTLCK, unsafe.Pointer(&unlock)); err != nil {
+			return err
+		}
+		return ioctl(fd, syscall.TIOCGPTN, unsafe.Pointer(&n))
+	})
+	if err == nil {
+		slave, err = OpenTTY(fmt.Sprintf("/dev/pts/%d", n), Config{})
+	}
+	if err == nil {
+		err = control(master, func(fd uintptr) error {
+			var t syscall.Termios
+			if err := ioctl(fd, syscall.TCGETS, unsafe.Pointer(&t)); err != nil {
+				return err
+			}
+			makeRaw(&t)
+			return ioctl(fd, syscall.TCSETS, unsafe.Pointer(&t))
+		})
+	}
+	if err != nil {
+		_ = master.Close()
+		if slave != nil {
+			_ = slave.Close()
+		}
+		return nil, nil, err
+	}
+	return master, slave, nil
+}
+
+// makeRaw 与 cfmakeraw 相同：关闭回显、行缓冲、信号与输入输出转换，按字节读取
+func makeRaw(t *syscall.Termios) {
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON | syscall.IXOFF
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+}
+
+// control 在文件描述符上执行 f
+func control(file *os.File, f func(fd uintptr) error) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ferr error
+	if err = conn.Control(func(fd uintptr) { ferr = f(fd) }); err != nil {
+		return err
+	}
+	return ferr
+}
+
+// ioctl 执行 ioctl 系统调用
+func ioctl(fd uintptr, req uint, arg unsafe.Pointer) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, uintptr(req), uintptr(arg)); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+//go:build linux
+
+package transport
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+// readFull 在 timeout 内从 f 读取 n 字节
+func readFull(t *testing.T, f *os.File, n int, timeout time.Duration) []byte {
+	t.Helper()
+	if err := f.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatalf("read %d bytes: %v", n, err)
+	}
+	return buf
+}
+
+func TestPTYRoundTrip(t *testing.T) {
+	master, slave, err := OpenPTY()
+	if os.IsNotExist(err) || os.IsPermission(err) {
+		t.Skipf("pty unavailable: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	// raw 模式下换行、中断与流控字符按原样传输
+	data := []byte{0xCA, 0xFE, '\r', '\n', 0x03, 0x11, 0x13, 0x7F, 0x00, 0xFF}
+	for i := 0; i < 100; i++ {
+		data = append(data, byte(i))
+	}
+	if _, err = master.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFull(t, slave, len(data), 2*time.Second); !bytes.Equal(got, data) {
+		t.Errorf("master -> slave: got % x", got)
+	}
+	if _, err = slave.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFull(t, master, len(data), 2*time.Second); !bytes.Equal(got, data) {
+		t.Errorf("slave -> master: got % x", got)
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	master, slave, err := OpenPTY()
+	if os.IsNotExist(err) || os.IsPermission(err) {
+		t.Skipf("pty unavailable: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer master.Close()
+	defer slave.Close()
+
+	tests := []struct {
+		config Config
+		set    uint32
+	}{
+		{config: Config{}, set: syscall.B115200},
+		{config: Config{BaudRate: 9600, StopBits: StopBits2}, set: syscall.B9600 | syscall.CSTOPB},
+		{config: Config{BaudRate: 57600, DataBits: 7, Parity: ParityOdd}, set: syscall.B57600},
+	}
+	for _, tt := range tests {
+		if err = Configure(slave, tt.config); err != nil {
+			t.Fatalf("%+v: %v", tt.config, err)
+		}
+		var term syscall.Termios
+		err = control(slave, func(fd uintptr) error { return ioctl(fd, syscall.TCGETS, unsafe.Pointer(&term)) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 伪终端驱动固定使用 8 位数据位、无校验，只检查波特率与停止位
+		mask := speedMask | syscall.CSTOPB
+		if got := term.Cflag & mask; got != tt.set {
+			t.Errorf("%+v: cflag %#o, want %#o", tt.config, got, tt.set)
+		}
+	}
+	if err = Configure(slave, Config{BaudRate: 12345}); err == nil {
+		t.Error("unsupported baud rate accepted")
+	}
+}
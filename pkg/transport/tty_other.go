@@ -0,0 +1,20 @@
+//go:build !linux
+
+package transport
+
+import "os"
+
+// OpenTTY 打开物理串口设备，当前平台不支持
+func OpenTTY(path string, config Config) (*os.File, error) {
+	return nil, ErrUnsupported
+}
+
+// Configure 配置物理串口，当前平台不支持
+func Configure(f *os.File, config Config) error {
+	return ErrUnsupported
+}
+
+// OpenPTY 创建一对伪终端，当前平台不支持
+func OpenPTY() (master, slave *os.File, err error) {
+	return nil, nil, ErrUnsupported
+}
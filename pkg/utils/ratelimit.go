@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"sync"
+	"time"
+)
+
+// RateLimiter 令牌桶限速器，按字节数限速
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64   // 每秒补充的令牌数
+	burst  float64   // 令牌桶容量
+	tokens float64   // 当前令牌数，可为负数表示欠账
+	last   time.Time // 上次补充令牌的时间
+}
+
+// NewRateLimiter 创建限速器，rate 为每秒允许的字节数，burst 为允许的突发字节数
+func NewRateLimiter(rate, burst int) *RateLimiter {
+	if burst <= 0 {
+		burst = rate
+	}
+	return &RateLimiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait 消耗 n 个令牌，令牌不足时阻塞等待补充；
+// n 超过桶容量时允许欠账，由后续调用等待偿还
+func (l *RateLimiter) Wait(n int) {
+	if l == nil || l.rate <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
@@ -70,7 +70,8 @@ func (pool *TaskIDPool) RecycleTaskID(taskID int) {
 	for _, segment := range pool.segments {
 		// 在锁外进行通道操作，防止死锁
 		segment.mu.Lock()
-		if taskID >= segment.nextID-1 && taskID <= segment.maxID {
+		// 只回收该段已分配过的任务 ID
+		if taskID >= 1 && taskID < segment.nextID && taskID <= segment.maxID {
 			segment.mu.Unlock()
 			// 使用非阻塞通道写入
 			select {